@@ -0,0 +1,487 @@
+package dokvs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/georgemac/dokvs/pkg/kv"
+)
+
+// keyspaceChanges are the deletions and puts to apply to a single keyspace.
+// Deletions retain the value they remove, which for the keyspace of a unique
+// constraint is the primary key releasing the value.
+type keyspaceChanges struct {
+	deletes []kv.Item
+	puts    []kv.Item
+}
+
+func (k *keyspaceChanges) delete(key, value []byte) {
+	k.deletes = append(k.deletes, kv.Item{K: key, V: value})
+}
+
+func (k *keyspaceChanges) put(key, value []byte) {
+	k.puts = append(k.puts, kv.Item{K: key, V: value})
+}
+
+// deleted returns the keys of the deletions.
+func (k keyspaceChanges) deleted() [][]byte {
+	keys := make([][]byte, len(k.deletes))
+	for i, item := range k.deletes {
+		keys[i] = item.K
+	}
+
+	return keys
+}
+
+// apply applies all deletions before any puts so that a key which is
+// both removed and inserted within a batch ends up present.
+func (k keyspaceChanges) apply(ctx context.Context, update kv.KeyspaceUpdate) error {
+	if len(k.deletes) > 0 {
+		if err := update.DeleteMany(ctx, k.deleted()...); err != nil {
+			return err
+		}
+	}
+
+	if len(k.puts) > 0 {
+		return update.PutMany(ctx, k.puts...)
+	}
+
+	return nil
+}
+
+// claim claims the values put by the changes to the keyspace of the named
+// unique constraint. Each claim is put on the condition that the value is
+// unclaimed, such that two writers cannot both claim a value on backends whose
+// updates are not transactional (e.g. etcd). A value held by a document which
+// releases it within the same changes is transferred on the condition that it
+// is unchanged since it was read. Values are only released once the documents
+// are written (see release). It returns the values newly claimed, along with
+// their previous holders, including when it fails part way.
+func (k keyspaceChanges) claim(ctx context.Context, update kv.KeyspaceUpdate, constraint string) (claimed []kv.Item, err error) {
+	for _, item := range k.puts {
+		err := update.Put(ctx, item.K, item.V, kv.IfAbsent())
+		if err == nil {
+			claimed = append(claimed, kv.Item{K: item.K})
+			continue
+		}
+
+		if !errors.Is(err, kv.ErrKeyExists) {
+			return claimed, err
+		}
+
+		items, err := update.Get(ctx, kv.Key(item.K))
+		if err != nil {
+			return claimed, err
+		}
+
+		holder := items[0].V
+
+		// the value may already be held by the claiming document
+		if bytes.Equal(holder, item.V) {
+			continue
+		}
+
+		if !k.releases(item.K, holder) {
+			return claimed, &ErrUniqueViolation{
+				Constraint: constraint,
+				Key:        append([]byte(nil), holder...),
+			}
+		}
+
+		if err := update.Put(ctx, item.K, item.V, kv.IfVersion(items[0].Version)); err != nil {
+			return claimed, err
+		}
+
+		claimed = append(claimed, kv.Item{K: item.K, V: holder})
+	}
+
+	return claimed, nil
+}
+
+// releases reports whether the changes release the value held by holder.
+func (k keyspaceChanges) releases(value, holder []byte) bool {
+	for _, item := range k.deletes {
+		if bytes.Equal(item.K, value) && bytes.Equal(item.V, holder) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unclaim reverts the values claimed by claim to their previous holders. Each
+// is only reverted while it is still held by the claiming document, by deleting
+// it when it was unclaimed or otherwise by a put conditional on its version.
+// It is best effort as it is only called once a write has already failed.
+func (k keyspaceChanges) unclaim(ctx context.Context, update kv.KeyspaceUpdate, claimed []kv.Item) {
+	for _, item := range claimed {
+		items, err := update.Get(ctx, kv.Key(item.K))
+		if err != nil || !bytes.Equal(items[0].V, k.claimant(item.K)) {
+			continue
+		}
+
+		if item.V == nil {
+			_ = update.Delete(ctx, item.K)
+			continue
+		}
+
+		_ = update.Put(ctx, item.K, item.V, kv.IfVersion(items[0].Version))
+	}
+}
+
+// claimant returns the primary key which claims value within the changes.
+func (k keyspaceChanges) claimant(value []byte) []byte {
+	for _, item := range k.puts {
+		if bytes.Equal(item.K, value) {
+			return item.V
+		}
+	}
+
+	return nil
+}
+
+// release deletes the values released by the changes to the keyspace of a
+// unique constraint, other than those transferred to another document by claim.
+func (k keyspaceChanges) release(ctx context.Context, update kv.KeyspaceUpdate) error {
+	var keys [][]byte
+	for _, item := range k.deletes {
+		if k.claimant(item.K) == nil {
+			keys = append(keys, item.K)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return update.DeleteMany(ctx, keys...)
+}
+
+// derivedChanges are the changes to each of the index and unique
+// keyspaces derived from a set of document writes.
+type derivedChanges struct {
+	indexes []keyspaceChanges
+	unique  []keyspaceChanges
+}
+
+func (c CollectionUpdate[D, K]) newDerivedChanges() derivedChanges {
+	return derivedChanges{
+		indexes: make([]keyspaceChanges, len(c.indexUpdates)),
+		unique:  make([]keyspaceChanges, len(c.uniqueUpdates)),
+	}
+}
+
+// derive accumulates the derived changes for replacing old with new at pk.
+func (c CollectionUpdate[D, K]) derive(changes derivedChanges, pk []byte, old, new *D) {
+	c.indexChanges(changes.indexes, pk, old, new)
+	c.uniqueChanges(changes.unique, pk, old, new)
+}
+
+func (c CollectionUpdate[D, K]) applyDerived(ctx context.Context, changes derivedChanges) error {
+	if _, err := c.applyUnique(ctx, changes); err != nil {
+		return err
+	}
+
+	return c.settleDerived(ctx, changes)
+}
+
+// settleDerived applies the changes which follow the write of the documents:
+// the release of unique values and the changes to each index keyspace.
+func (c CollectionUpdate[D, K]) settleDerived(ctx context.Context, changes derivedChanges) error {
+	for i := range changes.unique {
+		if err := changes.unique[i].release(ctx, c.uniqueUpdates[i]); err != nil {
+			return err
+		}
+	}
+
+	for i := range changes.indexes {
+		if err := changes.indexes[i].apply(ctx, c.indexUpdates[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyUnique claims the unique values of the changes in each unique
+// constraint keyspace. Writes claim their unique values before the documents
+// are written, and call undo to revert the claims when the document write
+// fails, or otherwise settleDerived to release the values no longer held.
+// When a claim fails, the claims already made are reverted.
+func (c CollectionUpdate[D, K]) applyUnique(ctx context.Context, changes derivedChanges) (undo func(), err error) {
+	var (
+		constraints = uniqueConstraints(c.schema)
+		claims      = make([][]kv.Item, 0, len(changes.unique))
+	)
+
+	undo = func() {
+		for i := len(claims) - 1; i >= 0; i-- {
+			changes.unique[i].unclaim(ctx, c.uniqueUpdates[i], claims[i])
+		}
+	}
+
+	for i := range changes.unique {
+		claimed, err := changes.unique[i].claim(ctx, c.uniqueUpdates[i], constraints[i].Name)
+		claims = append(claims, claimed)
+		if err != nil {
+			undo()
+			return nil, err
+		}
+	}
+
+	return undo, nil
+}
+
+// updateDerived updates the index and unique keyspaces for replacing old with new at pk.
+// Either old or new may be nil.
+func (c CollectionUpdate[D, K]) updateDerived(ctx context.Context, pk []byte, old, new *D) error {
+	changes := c.newDerivedChanges()
+	c.derive(changes, pk, old, new)
+	return c.applyDerived(ctx, changes)
+}
+
+// PutMany puts all of the documents. On etcd the writes, along with the reads
+// of the documents they replace and of their unique values, are grouped into as
+// few transactions as the server limits allow, and on bolt they are applied
+// within the current transaction. When the same primary key occurs more than
+// once the last document for that key is written. Every document is validated
+// before any is written.
+func (c CollectionUpdate[D, K]) PutMany(ctx context.Context, docs ...D) error {
+	keys, docs := c.dedupe(docs)
+
+	olds, writtens, err := c.previousMany(ctx, keys)
+	if err != nil {
+		return err
+	}
+
+	items := make([]kv.Item, len(docs))
+	for i := range docs {
+		if err := c.runHooks(ctx, c.hooks.beforePut, olds[i], &docs[i]); err != nil {
+			return err
+		}
+
+		if !bytes.Equal(c.schema.PrimaryKey(docs[i]), keys[i]) {
+			return ErrPrimaryKeyChanged
+		}
+
+		if err := c.validate(docs[i]); err != nil {
+			return err
+		}
+
+		v, err := c.encode(keys[i], docs[i])
+		if err != nil {
+			return err
+		}
+
+		items[i] = kv.Item{K: keys[i], V: v}
+	}
+
+	if err := c.checkUniqueMany(ctx, keys, docs); err != nil {
+		return err
+	}
+
+	changes := c.newDerivedChanges()
+	for i := range docs {
+		c.derive(changes, keys[i], writtens[i], &docs[i])
+	}
+
+	undo, err := c.applyUnique(ctx, changes)
+	if err != nil {
+		return err
+	}
+
+	if err := c.update.PutMany(ctx, items...); err != nil {
+		undo()
+		return err
+	}
+
+	if err := c.settleDerived(ctx, changes); err != nil {
+		return err
+	}
+
+	for i := range docs {
+		if err := c.runHooks(ctx, c.hooks.afterPut, olds[i], &docs[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteMany deletes all of the documents. Like PutMany, the deletes
+// are grouped into as few requests as the backend allows.
+func (c CollectionUpdate[D, K]) DeleteMany(ctx context.Context, docs ...D) error {
+	keys, _ := c.dedupe(docs)
+
+	olds, writtens, err := c.previousMany(ctx, keys)
+	if err != nil {
+		return err
+	}
+
+	return c.deleteKeys(ctx, keys, olds, writtens)
+}
+
+// deleteKeys deletes the documents stored at keys, where olds are the
+// documents currently stored at each key and writtens are those documents
+// as written, running the delete hooks for those which are present and
+// maintaining derived keyspaces.
+func (c CollectionUpdate[D, K]) deleteKeys(ctx context.Context, keys [][]byte, olds, writtens []*D) error {
+	for _, old := range olds {
+		if old == nil {
+			continue
+		}
+
+		if err := c.runHooks(ctx, c.hooks.beforeDelete, old, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := c.update.DeleteMany(ctx, keys...); err != nil {
+		return err
+	}
+
+	changes := c.newDerivedChanges()
+	for i := range keys {
+		c.derive(changes, keys[i], writtens[i], nil)
+	}
+
+	if err := c.applyDerived(ctx, changes); err != nil {
+		return err
+	}
+
+	for _, old := range olds {
+		if old == nil {
+			continue
+		}
+
+		if err := c.runHooks(ctx, c.hooks.afterDelete, old, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dedupe returns the primary keys and documents with only the last
+// document retained for each primary key.
+func (c CollectionUpdate[D, K]) dedupe(docs []D) (keys [][]byte, deduped []D) {
+	positions := map[string]int{}
+	for _, doc := range docs {
+		key := c.schema.PrimaryKey(doc)
+		if i, ok := positions[string(key)]; ok {
+			deduped[i] = doc
+			continue
+		}
+
+		positions[string(key)] = len(keys)
+		keys = append(keys, key)
+		deduped = append(deduped, doc)
+	}
+
+	return
+}
+
+// previousMany is the batched equivalent of previous.
+func (c CollectionUpdate[D, K]) previousMany(ctx context.Context, keys [][]byte) (olds, writtens []*D, err error) {
+	olds, writtens = make([]*D, len(keys)), make([]*D, len(keys))
+	if len(keys) == 0 || !c.needsPrevious() {
+		return olds, writtens, nil
+	}
+
+	items, err := c.view.Get(ctx, kv.Batch(keys...))
+
+	var berr *kv.BatchError
+	if err != nil && !errors.As(err, &berr) {
+		return nil, nil, err
+	}
+
+	for i := range items {
+		if berr != nil && berr.Errors[i] != nil {
+			if errors.Is(berr.Errors[i], kv.ErrKeyNotFound) {
+				continue
+			}
+
+			return nil, nil, berr.Errors[i]
+		}
+
+		if olds[i], writtens[i], err = c.stored(keys[i], items[i].V); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return olds, writtens, nil
+}
+
+// checkUniqueMany is the batched equivalent of checkUnique.
+// It also rejects batches in which two documents share a unique value.
+// A value held in the store by a document which releases it within
+// the same batch is not considered a violation.
+func (c CollectionUpdate[D, K]) checkUniqueMany(ctx context.Context, keys [][]byte, docs []D) error {
+	positions := make(map[string]int, len(keys))
+	for i, key := range keys {
+		positions[string(key)] = i
+	}
+
+	for i, unique := range uniqueConstraints(c.schema) {
+		var (
+			values  = make([][]byte, len(docs))
+			holders = map[string][]byte{}
+			batch   [][]byte
+			owners  []int
+		)
+
+		for j := range docs {
+			if values[j] = unique.Value(docs[j]); values[j] == nil {
+				continue
+			}
+
+			if holder, ok := holders[string(values[j])]; ok {
+				return &ErrUniqueViolation{Constraint: unique.Name, Key: holder}
+			}
+
+			holders[string(values[j])] = keys[j]
+			batch = append(batch, values[j])
+			owners = append(owners, j)
+		}
+
+		if len(batch) == 0 {
+			continue
+		}
+
+		items, err := c.uniqueUpdates[i].Get(ctx, kv.Batch(batch...))
+
+		var berr *kv.BatchError
+		if err != nil && !errors.As(err, &berr) {
+			return err
+		}
+
+		for k := range items {
+			if berr != nil && berr.Errors[k] != nil {
+				if errors.Is(berr.Errors[k], kv.ErrKeyNotFound) {
+					continue
+				}
+
+				return berr.Errors[k]
+			}
+
+			holder := items[k].V
+			if bytes.Equal(holder, keys[owners[k]]) {
+				continue
+			}
+
+			// the holder releases the value if it is rewritten within
+			// the batch with a different value
+			if pos, ok := positions[string(holder)]; ok && !bytes.Equal(values[pos], batch[k]) {
+				continue
+			}
+
+			return &ErrUniqueViolation{
+				Constraint: unique.Name,
+				Key:        append([]byte(nil), holder...),
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,394 @@
+package dokvs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/georgemac/dokvs/pkg/kv"
+)
+
+// CatalogKeyspace is the reserved keyspace in which the description
+// of each initialized collection is recorded.
+const CatalogKeyspace = "_dokvs_catalog"
+
+// ErrCollectionNotFound is returned when a collection is not recorded in the catalog.
+var ErrCollectionNotFound = errors.New("collection not found")
+
+// ErrCatalogMismatch is returned by Collection.Init, View and Update when the
+// collection is configured differently to how it is recorded in the catalog.
+var ErrCatalogMismatch = errors.New("collection does not match catalog")
+
+// CollectionDescription is the entry recorded in the catalog for a collection.
+type CollectionDescription struct {
+	Name string `json:"name"`
+	// Serializer identifies the encoding of the documents (see IdentifiedSerializer).
+	Serializer string `json:"serializer"`
+	// Formats identifies the serializers in the registry of the collection (see WithRegistry).
+	Formats []string `json:"formats,omitempty"`
+	// Version is the latest schema version with which the collection was initialized.
+	Version uint64             `json:"version"`
+	Indexes []IndexDescription `json:"indexes,omitempty"`
+	// Unique is the name of each unique constraint on the collection.
+	Unique []string `json:"unique,omitempty"`
+	// Enveloped records that every document of the collection is stored within
+	// an envelope, as the collection was empty when it was first initialized or
+	// has since been migrated (see Collection.Migrate), such that no value is
+	// read as one written before envelopes were introduced.
+	Enveloped bool      `json:"enveloped,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// IndexDescription describes a secondary index of a collection.
+// Field is only set for indexes configured using WithFieldIndex.
+type IndexDescription struct {
+	Name  string `json:"name"`
+	Field string `json:"field,omitempty"`
+}
+
+// ListCollections returns the description of every collection in the catalog
+// ordered by name.
+func ListCollections(ctx context.Context, view kv.View) ([]CollectionDescription, error) {
+	catalog, err := view.Keyspace([]byte(CatalogKeyspace))
+	if err != nil {
+		if errors.Is(err, kv.ErrKeyspaceNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	items, err := catalog.Range(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	descs := make([]CollectionDescription, len(items))
+	for i, item := range items {
+		if err := json.Unmarshal(item.V, &descs[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return descs, nil
+}
+
+// DescribeCollection returns the description of the named collection from the catalog.
+func DescribeCollection(ctx context.Context, view kv.View, name string) (desc CollectionDescription, err error) {
+	catalog, err := view.Keyspace([]byte(CatalogKeyspace))
+	if err != nil {
+		if errors.Is(err, kv.ErrKeyspaceNotFound) {
+			err = fmt.Errorf("collection %q: %w", name, ErrCollectionNotFound)
+		}
+
+		return
+	}
+
+	desc, ok, err := describe(ctx, catalog, name)
+	if err == nil && !ok {
+		err = fmt.Errorf("collection %q: %w", name, ErrCollectionNotFound)
+	}
+
+	return
+}
+
+func describe(ctx context.Context, catalog kv.KeyspaceView, name string) (desc CollectionDescription, ok bool, err error) {
+	items, err := catalog.Get(ctx, kv.Key([]byte(name)))
+	if err != nil {
+		var berr *kv.BatchError
+		if errors.As(err, &berr) && errors.Is(berr.Errors[0], kv.ErrKeyNotFound) {
+			err = nil
+		}
+
+		return
+	}
+
+	if len(items) == 0 {
+		return
+	}
+
+	return desc, true, json.Unmarshal(items[0].V, &desc)
+}
+
+// description returns the catalog entry for the current configuration of the collection.
+func (c Collection[D, K]) description() CollectionDescription {
+	desc := CollectionDescription{
+		Name:       string(c.schema.Collection()),
+		Serializer: serializerID(c.serializer),
+		Version:    c.schema.Version(),
+	}
+
+	if c.registry != nil {
+		desc.Formats = c.registry.IDs()
+	}
+
+	for _, idx := range c.indexes {
+		desc.Indexes = append(desc.Indexes, IndexDescription{Name: idx.Name, Field: idx.Field})
+	}
+
+	for _, unique := range uniqueConstraints(c.schema) {
+		desc.Unique = append(desc.Unique, unique.Name)
+	}
+
+	sort.Slice(desc.Indexes, func(i, j int) bool { return desc.Indexes[i].Name < desc.Indexes[j].Name })
+	sort.Strings(desc.Unique)
+
+	return desc
+}
+
+// register records the collection in the catalog or, when already recorded,
+// validates that the collection matches its entry.
+func (c Collection[D, K]) register(ctx context.Context, update kv.Update) error {
+	if err := createKeyspace(update, []byte(CatalogKeyspace)); err != nil {
+		return err
+	}
+
+	catalog, err := update.Keyspace([]byte(CatalogKeyspace))
+	if err != nil {
+		return err
+	}
+
+	desc := c.description()
+
+	existing, ok, err := describe(ctx, catalog, desc.Name)
+	if err != nil {
+		return err
+	}
+
+	desc.Created = time.Now().UTC()
+	if ok {
+		if err := existing.matches(desc); err != nil {
+			return fmt.Errorf("collection %q: %w", desc.Name, err)
+		}
+
+		if existing.Version == desc.Version &&
+			existing.Serializer == desc.Serializer &&
+			reflect.DeepEqual(existing.Formats, desc.Formats) {
+			return nil
+		}
+
+		desc.Created, desc.Enveloped = existing.Created, existing.Enveloped
+	} else if desc.Enveloped, err = c.empty(ctx, update); err != nil {
+		return err
+	}
+
+	v, err := json.Marshal(desc)
+	if err != nil {
+		return err
+	}
+
+	return catalog.Put(ctx, []byte(desc.Name), v)
+}
+
+// empty reports whether the documents keyspace of the collection holds no documents.
+func (c Collection[D, K]) empty(ctx context.Context, update kv.Update) (bool, error) {
+	documents, err := update.Keyspace(c.schema.Collection())
+	if err != nil {
+		if errors.Is(err, kv.ErrKeyspaceNotFound) {
+			return true, nil
+		}
+
+		return false, err
+	}
+
+	n, err := documents.Count(ctx, kv.Limit(1))
+	return n == 0, err
+}
+
+// verify returns an error when the collection does not match its entry in the
+// catalog, such that a collection opened with a different serializer, indexes
+// or unique constraints to those it was initialized with cannot misread its
+// documents or neglect their derived state. When the collection is only read,
+// it may omit indexes and unique constraints. Collections which are not
+// recorded in the catalog are not verified. It returns the recorded entry,
+// which is empty for collections which are not recorded.
+func (c Collection[D, K]) verify(ctx context.Context, keyspace func([]byte) (kv.KeyspaceView, error), write bool) (CollectionDescription, error) {
+	catalog, err := keyspace([]byte(CatalogKeyspace))
+	if err != nil {
+		if errors.Is(err, kv.ErrKeyspaceNotFound) {
+			return CollectionDescription{}, nil
+		}
+
+		return CollectionDescription{}, err
+	}
+
+	desc := c.description()
+
+	existing, ok, err := describe(ctx, catalog, desc.Name)
+	if err != nil || !ok {
+		return CollectionDescription{}, err
+	}
+
+	check := existing.readableBy
+	if write {
+		check = existing.matches
+	}
+
+	if err := check(desc); err != nil {
+		return CollectionDescription{}, fmt.Errorf("collection %q: %w", desc.Name, err)
+	}
+
+	return existing, nil
+}
+
+// markEnveloped records in the catalog that every document of the named
+// collection is enveloped. Collections which are not recorded are unchanged.
+func markEnveloped(ctx context.Context, update kv.Update, name []byte) error {
+	catalog, err := update.Keyspace([]byte(CatalogKeyspace))
+	if err != nil {
+		if errors.Is(err, kv.ErrKeyspaceNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	desc, ok, err := describe(ctx, catalog, string(name))
+	if err != nil || !ok || desc.Enveloped {
+		return err
+	}
+
+	desc.Enveloped = true
+
+	v, err := json.Marshal(desc)
+	if err != nil {
+		return err
+	}
+
+	return catalog.Put(ctx, name, v)
+}
+
+// matches returns an error when other cannot open a collection described by d.
+// Schema versions may only increase and the serializer may only change when
+// the previous serializer is in the registry of other.
+func (d CollectionDescription) matches(other CollectionDescription) error {
+	switch {
+	case d.Serializer != other.Serializer && !other.reads(d.Serializer):
+		return fmt.Errorf("serializer %q recorded as %q: %w", other.Serializer, d.Serializer, ErrCatalogMismatch)
+	case !reflect.DeepEqual(d.Indexes, other.Indexes):
+		return fmt.Errorf("indexes %v recorded as %v: %w", other.Indexes, d.Indexes, ErrCatalogMismatch)
+	case !reflect.DeepEqual(d.Unique, other.Unique):
+		return fmt.Errorf("unique constraints %v recorded as %v: %w", other.Unique, d.Unique, ErrCatalogMismatch)
+	case d.Version > other.Version:
+		return fmt.Errorf("version %d recorded as %d: %w", other.Version, d.Version, ErrUnsupportedVersion)
+	}
+
+	return nil
+}
+
+// readableBy returns an error when other cannot read a collection described by d.
+// Unlike matches, other may omit indexes and unique constraints, which are only
+// maintained by writes, although each index it configures must be recorded.
+func (d CollectionDescription) readableBy(other CollectionDescription) error {
+	switch {
+	case d.Serializer != other.Serializer && !other.reads(d.Serializer):
+		return fmt.Errorf("serializer %q recorded as %q: %w", other.Serializer, d.Serializer, ErrCatalogMismatch)
+	case !d.indexes(other.Indexes):
+		return fmt.Errorf("indexes %v recorded as %v: %w", other.Indexes, d.Indexes, ErrCatalogMismatch)
+	case d.Version > other.Version:
+		return fmt.Errorf("version %d recorded as %d: %w", other.Version, d.Version, ErrUnsupportedVersion)
+	}
+
+	return nil
+}
+
+// indexes reports whether each of the indexes is recorded in d.
+func (d CollectionDescription) indexes(indexes []IndexDescription) bool {
+	for _, idx := range indexes {
+		var found bool
+		for _, recorded := range d.Indexes {
+			if found = recorded == idx; found {
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// unregister removes the named collection from the catalog.
+func unregister(ctx context.Context, update kv.Update, name []byte) error {
+	catalog, err := update.Keyspace([]byte(CatalogKeyspace))
+	if err != nil {
+		if errors.Is(err, kv.ErrKeyspaceNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	return catalog.Delete(ctx, name)
+}
+
+// registered reports whether the named collection is recorded in the catalog.
+func registered(ctx context.Context, update kv.Update, name []byte) (bool, error) {
+	catalog, err := update.Keyspace([]byte(CatalogKeyspace))
+	if err != nil {
+		if errors.Is(err, kv.ErrKeyspaceNotFound) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	_, ok, err := describe(ctx, catalog, string(name))
+	return ok, err
+}
+
+// rename moves the catalog entry of a collection to a new name.
+func rename(ctx context.Context, update kv.Update, from, to []byte) error {
+	catalog, err := update.Keyspace([]byte(CatalogKeyspace))
+	if err != nil {
+		if errors.Is(err, kv.ErrKeyspaceNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	desc, ok, err := describe(ctx, catalog, string(from))
+	if err != nil || !ok {
+		return err
+	}
+
+	desc.Name = string(to)
+
+	v, err := json.Marshal(desc)
+	if err != nil {
+		return err
+	}
+
+	if err := catalog.Put(ctx, to, v); err != nil {
+		return err
+	}
+
+	return catalog.Delete(ctx, from)
+}
+
+// reads reports whether the collection can read values written by the serializer.
+func (d CollectionDescription) reads(serializer string) bool {
+	for _, format := range d.Formats {
+		if format == serializer {
+			return true
+		}
+	}
+
+	return false
+}
+
+// createKeyspace creates the keyspace unless it already exists.
+func createKeyspace(update kv.Update, name []byte) error {
+	if err := update.CreateKeyspace(name); err != nil && !errors.Is(err, kv.ErrKeyspaceExists) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+// Command dokvs-query executes a query against a collection of JSON documents
+// stored in a bolt file or an etcd cluster and prints each matching document
+// as a line of JSON.
+//
+// Usage:
+//
+//	dokvs-query -bolt app.db -collection books 'author = "bob" ORDER BY created DESC LIMIT 20'
+//	dokvs-query -etcd localhost:2379 -collection books -index author -explain 'author = "bob"'
+//
+// Field indexes configured on the collection using dokvs.WithFieldIndex are
+// read from the catalog. A subset of them can be selected by passing their
+// fields with -index, for collections recorded in the catalog.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/georgemac/dokvs"
+	"github.com/georgemac/dokvs/pkg/kv"
+	"github.com/georgemac/dokvs/pkg/kv/boltdb"
+	"github.com/georgemac/dokvs/pkg/kv/etcd"
+	bolt "go.etcd.io/bbolt"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+type fields []string
+
+func (f *fields) String() string     { return strings.Join(*f, ",") }
+func (f *fields) Set(v string) error { *f = append(*f, v); return nil }
+
+func main() {
+	var (
+		boltPath   = flag.String("bolt", "", "path to a bolt database file")
+		endpoints  = flag.String("etcd", "", "comma separated etcd endpoints")
+		collection = flag.String("collection", "", "name of the collection to query")
+		explain    = flag.Bool("explain", false, "print the query plan rather than execute it")
+		indexes    fields
+	)
+
+	flag.Var(&indexes, "index", "field index configured on the collection (repeatable, defaults to the catalog)")
+	flag.Parse()
+
+	if *collection == "" || flag.NArg() != 1 || (*boltPath == "") == (*endpoints == "") {
+		fmt.Fprintln(os.Stderr, "usage: dokvs-query (-bolt path | -etcd endpoints) -collection name [-index field]... [-explain] query")
+		os.Exit(2)
+	}
+
+	store, closeStore, err := open(*boltPath, *endpoints)
+	if err != nil {
+		fatal(err)
+	}
+
+	defer closeStore()
+
+	if err := run(store, *collection, indexes, *explain, flag.Arg(0)); err != nil {
+		closeStore()
+		fatal(err)
+	}
+}
+
+func run(store kv.Store, name string, indexes []string, explain bool, q string) error {
+	// documents are only read so the primary key is never derived
+	schema := dokvs.NewSchema(name, func(map[string]any) []byte { return nil })
+
+	return store.View(func(tx kv.View) error {
+		ctx := context.Background()
+
+		// field indexes recorded in the catalog are used unless a subset is selected
+		if len(indexes) == 0 {
+			desc, err := dokvs.DescribeCollection(ctx, tx, name)
+			if err != nil && !errors.Is(err, dokvs.ErrCollectionNotFound) {
+				return err
+			}
+
+			for _, idx := range desc.Indexes {
+				if idx.Field != "" {
+					indexes = append(indexes, idx.Field)
+				}
+			}
+		}
+
+		var opts []func(*dokvs.Collection[map[string]any, []byte])
+		for _, field := range indexes {
+			opts = append(opts, dokvs.WithFieldIndex[map[string]any, []byte](field))
+		}
+
+		view, err := dokvs.NewCollection(schema, opts...).View(tx)
+		if err != nil {
+			return err
+		}
+
+		if explain {
+			plan, err := view.Explain(q)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println(plan)
+			return nil
+		}
+
+		docs, err := view.Query(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		for _, doc := range docs {
+			if err := enc.Encode(doc); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func open(boltPath, endpoints string) (kv.Store, func() error, error) {
+	if boltPath != "" {
+		db, err := bolt.Open(boltPath, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return boltdb.New(db), db.Close, nil
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   strings.Split(endpoints, ","),
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return etcd.New(client.KV), client.Close, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "dokvs-query:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,260 @@
+package dokvs
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultCompressionThreshold is the size in bytes from which serialized
+// documents are compressed by a CompressedSerializer.
+const DefaultCompressionThreshold = 1024
+
+// DefaultMaxDecompressedSize is the size in bytes beyond which a
+// CompressedSerializer refuses to decompress a value.
+const DefaultMaxDecompressedSize = 64 << 20
+
+var (
+	// ErrUnknownCompression is returned when a value begins with an unknown compression header.
+	ErrUnknownCompression = errors.New("unknown compression")
+	// ErrDecompressedTooLarge is returned when a value decompresses to more
+	// than the maximum size configured for a CompressedSerializer.
+	ErrDecompressedTooLarge = errors.New("decompressed value too large")
+)
+
+// Compression identifies the algorithm used to compress a value.
+// It is stored as the first byte of every value written by a CompressedSerializer.
+type Compression byte
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionZlib
+	CompressionFlate
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZlib:
+		return "zlib"
+	case CompressionFlate:
+		return "flate"
+	}
+
+	return fmt.Sprintf("Compression(%d)", byte(c))
+}
+
+// CompressedSerializer wraps a Serializer compressing the values it produces
+// which are at least the configured threshold in size. Every value is
+// prefixed with a header byte identifying its compression, so values written
+// uncompressed or using any other Compression remain readable.
+type CompressedSerializer[T any] struct {
+	serializer  Serializer[T]
+	compression Compression
+	threshold   int
+	max         int64
+}
+
+// WithCompressionThreshold configures the size in bytes from which values are compressed.
+func WithCompressionThreshold[T any](n int) func(*CompressedSerializer[T]) {
+	return func(s *CompressedSerializer[T]) {
+		s.threshold = n
+	}
+}
+
+// WithMaxDecompressedSize configures the size in bytes beyond which values
+// are not decompressed, guarding against values which expand to exhaust memory.
+func WithMaxDecompressedSize[T any](n int64) func(*CompressedSerializer[T]) {
+	return func(s *CompressedSerializer[T]) {
+		s.max = n
+	}
+}
+
+// NewCompressedSerializer returns a CompressedSerializer which compresses the
+// output of serializer using the provided compression.
+func NewCompressedSerializer[T any](serializer Serializer[T], compression Compression, opts ...func(*CompressedSerializer[T])) CompressedSerializer[T] {
+	s := CompressedSerializer[T]{
+		serializer:  serializer,
+		compression: compression,
+		threshold:   DefaultCompressionThreshold,
+		max:         DefaultMaxDecompressedSize,
+	}
+
+	ApplyAll(&s, opts...)
+
+	return s
+}
+
+// ID identifies the wrapped serializer. It does not include the compression
+// as values written using any compression can be read.
+func (s CompressedSerializer[T]) ID() string {
+	return "compressed:" + serializerID(s.serializer)
+}
+
+func (s CompressedSerializer[T]) Serialize(t T) ([]byte, error) {
+	return s.serialize(t, nil)
+}
+
+func (s CompressedSerializer[T]) serialize(t T, ad []byte) ([]byte, error) {
+	v, err := serialize(s.serializer, t, ad)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.compression != CompressionNone && len(v) >= s.threshold {
+		compressed, err := compress(s.compression, v)
+		if err != nil {
+			return nil, err
+		}
+
+		// values which do not compress are stored as they are
+		if len(compressed) < len(v) {
+			return append([]byte{byte(s.compression)}, compressed...), nil
+		}
+	}
+
+	return append([]byte{byte(CompressionNone)}, v...), nil
+}
+
+func (s CompressedSerializer[T]) Deserialize(v []byte, t *T) error {
+	v, err := decompress(v, s.max)
+	if err != nil {
+		return err
+	}
+
+	return s.serializer.Deserialize(v, t)
+}
+
+// DeserializeFields decompresses v and projects it onto fields when the
+// wrapped serializer is a FieldDeserializer, and otherwise deserializes all of v.
+func (s CompressedSerializer[T]) DeserializeFields(v []byte, t *T, fields []string) error {
+	v, err := decompress(v, s.max)
+	if err != nil {
+		return err
+	}
+
+	if fd, ok := s.serializer.(FieldDeserializer[T]); ok {
+		return fd.DeserializeFields(v, t, fields)
+	}
+
+	return s.serializer.Deserialize(v, t)
+}
+
+func (s CompressedSerializer[T]) unwrap(v, _ []byte) ([]byte, Serializer[T], error) {
+	v, err := decompress(v, s.max)
+	return v, s.serializer, err
+}
+
+// rebind rebinds the output of the wrapped serializer within v, which is
+// compressed again as it was written.
+func (s CompressedSerializer[T]) rebind(v, from, to []byte) ([]byte, error) {
+	if !s.binds() {
+		return v, nil
+	}
+
+	inner, err := decompress(v, s.max)
+	if err != nil {
+		return nil, err
+	}
+
+	if inner, err = rebind(s.serializer, inner, from, to); err != nil {
+		return nil, err
+	}
+
+	if compression := Compression(v[0]); compression != CompressionNone {
+		if inner, err = compress(compression, inner); err != nil {
+			return nil, err
+		}
+	}
+
+	return append([]byte{v[0]}, inner...), nil
+}
+
+func (s CompressedSerializer[T]) binds() bool { return binds(s.serializer) }
+
+func compress(compression Compression, v []byte) ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		w   io.WriteCloser
+		err error
+	)
+
+	switch compression {
+	case CompressionGzip:
+		w = gzip.NewWriter(&buf)
+	case CompressionZlib:
+		w = zlib.NewWriter(&buf)
+	case CompressionFlate:
+		w, err = flate.NewWriter(&buf, flate.DefaultCompression)
+	default:
+		return nil, fmt.Errorf("%v: %w", compression, ErrUnknownCompression)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(v); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decompress reads the compression header of v and returns its decompressed
+// contents, failing with ErrDecompressedTooLarge once they exceed max bytes.
+func decompress(v []byte, max int64) ([]byte, error) {
+	if len(v) == 0 {
+		return nil, fmt.Errorf("missing header: %w", ErrUnknownCompression)
+	}
+
+	var (
+		compression = Compression(v[0])
+		src         = bytes.NewReader(v[1:])
+		r           io.ReadCloser
+		err         error
+	)
+
+	switch compression {
+	case CompressionNone:
+		return v[1:], nil
+	case CompressionGzip:
+		r, err = gzip.NewReader(src)
+	case CompressionZlib:
+		r, err = zlib.NewReader(src)
+	case CompressionFlate:
+		r = flate.NewReader(src)
+	default:
+		return nil, fmt.Errorf("%v: %w", compression, ErrUnknownCompression)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	// read a byte beyond max to tell values of exactly max bytes from larger ones
+	d, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(d)) > max {
+		return nil, fmt.Errorf("%v: exceeds %d bytes: %w", compression, max, ErrDecompressedTooLarge)
+	}
+
+	return d, nil
+}
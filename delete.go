@@ -0,0 +1,134 @@
+package dokvs
+
+import (
+	"context"
+
+	"github.com/georgemac/dokvs/pkg/kv"
+)
+
+// deleteBatchSize is the number of documents read before they are
+// deleted when a range delete must maintain derived keyspaces.
+const deleteBatchSize = 100
+
+// DeleteKey deletes the document stored at key.
+// It reports whether a document was removed.
+func (c CollectionUpdate[D, K]) DeleteKey(ctx context.Context, key K) (bool, error) {
+	pk := []byte(key)
+
+	old, written, err := c.previous(ctx, pk)
+	if err != nil {
+		return false, err
+	}
+
+	if old != nil {
+		if err := c.runHooks(ctx, c.hooks.beforeDelete, old, nil); err != nil {
+			return false, err
+		}
+	}
+
+	// a range over exactly pk reports whether it was present
+	n, err := c.update.DeleteRange(ctx, kv.Start(pk), kv.End(append(append([]byte(nil), pk...), 0x00)))
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	if err := c.updateDerived(ctx, pk, written, nil); err != nil {
+		return true, err
+	}
+
+	if old == nil {
+		return true, nil
+	}
+
+	return true, c.runHooks(ctx, c.hooks.afterDelete, old, nil)
+}
+
+// DeleteRange deletes every document with a primary key within the bounds
+// of the range (see kv.RangeOptions) and returns the number deleted.
+// When the collection has no indexes, unique constraints or delete hooks,
+// the range is deleted natively by the backend without reading any documents.
+func (c CollectionUpdate[D, K]) DeleteRange(ctx context.Context, opts ...kv.RangeOption) (int, error) {
+	if !c.hasDerived() && !c.hooks.onDelete() {
+		return c.update.DeleteRange(ctx, opts...)
+	}
+
+	return c.deleteWhere(ctx, func(D) bool { return true }, opts...)
+}
+
+// DeleteWhere deletes every document for which pred returns true
+// and returns the number deleted.
+func (c CollectionUpdate[D, K]) DeleteWhere(ctx context.Context, pred func(D) bool) (int, error) {
+	return c.deleteWhere(ctx, pred)
+}
+
+// deleteWhere walks the range in batches, deleting the documents which match
+// pred along with their derived state. Each batch is read in full before it is
+// deleted so that no cursor is in use while the keyspace is modified.
+func (c CollectionUpdate[D, K]) deleteWhere(ctx context.Context, pred func(D) bool, opts ...kv.RangeOption) (deleted int, err error) {
+	var rng kv.RangeOptions
+	for _, opt := range opts {
+		opt(&rng)
+	}
+
+	start, end := rng.Bounds()
+
+	for {
+		var (
+			keys     [][]byte
+			docs     []D
+			writtens []*D
+			read     int
+			after    []byte
+		)
+
+		err := c.iterateItems(ctx, func(item kv.Item, d D) error {
+			read++
+			after = append(append([]byte(nil), item.K...), 0x00)
+
+			if pred(d) {
+				env, err := decodeEnvelope(item.V, c.enveloped)
+				if err != nil {
+					return err
+				}
+
+				keys = append(keys, append([]byte(nil), item.K...))
+				docs = append(docs, d)
+				writtens = append(writtens, c.written(item.K, env, &d))
+			}
+
+			if read >= deleteBatchSize {
+				return errStopIteration
+			}
+
+			return nil
+		}, kv.Start(start), kv.End(end))
+		if err != nil {
+			return deleted, err
+		}
+
+		if len(keys) > 0 {
+			olds := make([]*D, len(docs))
+			for i := range docs {
+				olds[i] = &docs[i]
+			}
+
+			if err := c.deleteKeys(ctx, keys, olds, writtens); err != nil {
+				return deleted, err
+			}
+
+			deleted += len(keys)
+		}
+
+		if read < deleteBatchSize {
+			return deleted, nil
+		}
+
+		start = after
+	}
+}
+
+// hasDerived returns true when the collection maintains keyspaces
+// derived from its documents, such as indexes and unique constraints.
+func (c CollectionUpdate[D, K]) hasDerived() bool {
+	return len(c.indexUpdates) > 0 || len(c.uniqueUpdates) > 0
+}
@@ -1,43 +1,112 @@
 package dokvs
 
 import (
+	"bytes"
 	"context"
 	"errors"
 
+	"github.com/georgemac/dokvs/pkg/keyenc"
 	"github.com/georgemac/dokvs/pkg/kv"
 )
 
 var ErrNotFound = errors.New("document not found")
 
+// ErrAlreadyExists is returned when inserting a document whose primary key is already present.
+var ErrAlreadyExists = errors.New("document already exists")
+
+// ErrPrimaryKeyChanged is returned when a Modify mutator or BeforePut hook
+// changes the primary key of a document.
+var ErrPrimaryKeyChanged = errors.New("primary key changed")
+
+// ErrConflict is returned when a conditional write is rejected because
+// the stored revision of the document has changed.
+var ErrConflict = errors.New("document revision conflict")
+
+// Revision identifies a particular version of a stored document.
+// It changes each time the document is put and is zero for absent documents.
+type Revision int64
+
 type Serializer[T any] interface {
 	Serialize(T) ([]byte, error)
 	Deserialize([]byte, *T) error
 }
 
+// FieldDeserializer is implemented by serializers which can deserialize
+// only the named fields of a document, leaving all others as their zero value.
+type FieldDeserializer[T any] interface {
+	DeserializeFields(v []byte, t *T, fields []string) error
+}
+
 type AnyBytes interface {
 	~[]byte | ~string
 }
 
+// CollectionSchema describes the documents of a collection. A schema may
+// also declare unique constraints by implementing UniqueSchema.
 type CollectionSchema[D any] interface {
 	Collection() []byte
 	PrimaryKey(D) []byte
+	// Version is the version of the documents written using the schema.
+	Version() uint64
+	// Upgrades is the chain of upgrades between versions of the schema,
+	// where Upgrades()[i] upgrades a document from version i+1 to i+2.
+	Upgrades() []Upgrade
 }
 
 type Schema[D any] struct {
 	collection   []byte
 	primaryKeyFn func(D) []byte
+	unique       []UniqueConstraint[D]
+	upgrades     []Upgrade
 }
 
-func (s Schema[D]) Collection() []byte    { return s.collection }
-func (s Schema[D]) PrimaryKey(d D) []byte { return s.primaryKeyFn(d) }
+func (s Schema[D]) Collection() []byte                       { return s.collection }
+func (s Schema[D]) PrimaryKey(d D) []byte                    { return s.primaryKeyFn(d) }
+func (s Schema[D]) UniqueConstraints() []UniqueConstraint[D] { return s.unique }
+func (s Schema[D]) Upgrades() []Upgrade                      { return s.upgrades }
+
+// Version is one more than the number of upgrades configured on the schema,
+// such that a schema without upgrades is at version 1.
+func (s Schema[D]) Version() uint64 { return uint64(len(s.upgrades)) + 1 }
+
+// NewSchema returns a CollectionSchema for the named collection.
+// The primary key function may return any byte-like type, for example
+// a keyenc.Key, which allows keys to be built from typed tuples whose
+// order in the store matches their semantic order.
+//
+// Documents are stored within an envelope recording the schema version.
+// A collection holding documents written before envelopes were introduced
+// reads any value which is not a well formed envelope as one of them. Until
+// such a collection is migrated (see Collection.Migrate), a value written by
+// a serializer whose output happens to form a well formed envelope, which
+// begins with the byte 0xDC, is misread. Collections which were empty when
+// first initialized (see Collection.Init) are not affected.
+func NewSchema[D any, P AnyBytes](name string, primaryKeyFn func(D) P, opts ...func(*Schema[D])) CollectionSchema[D] {
+	s := Schema[D]{
+		collection:   []byte(name),
+		primaryKeyFn: func(d D) []byte { return []byte(primaryKeyFn(d)) },
+	}
+
+	ApplyAll(&s, opts...)
 
-func NewSchema[D any](name string, primaryKeyFn func(D) []byte) CollectionSchema[D] {
-	return Schema[D]{collection: []byte(name), primaryKeyFn: primaryKeyFn}
+	return s
 }
 
+// DefaultModifyRetries is the default number of times Modify retries
+// after a conflicting concurrent write.
+const DefaultModifyRetries = 10
+
 type Collection[D any, K AnyBytes] struct {
-	schema     CollectionSchema[D]
-	serializer Serializer[D]
+	schema        CollectionSchema[D]
+	serializer    Serializer[D]
+	indexes       []Index[D]
+	modifyRetries int
+	registry      *Registry[D]
+	validators    []func(D) error
+	hooks         hooks[D]
+	// enveloped is set by View and Update when the catalog records
+	// that every document of the collection is enveloped.
+	enveloped bool
 }
 
 func WithSerializer[D any, K AnyBytes](serializer Serializer[D]) func(*Collection[D, K]) {
@@ -46,100 +115,521 @@ func WithSerializer[D any, K AnyBytes](serializer Serializer[D]) func(*Collectio
 	}
 }
 
+// WithModifyRetries configures the number of times Modify retries after
+// its write is rejected due to a conflicting concurrent write.
+func WithModifyRetries[D any, K AnyBytes](n int) func(*Collection[D, K]) {
+	return func(c *Collection[D, K]) {
+		c.modifyRetries = n
+	}
+}
+
 func NewCollection[D any, K AnyBytes](schema CollectionSchema[D], opts ...func(*Collection[D, K])) Collection[D, K] {
-	c := Collection[D, K]{schema: schema, serializer: JSONSerializer[D]{}}
+	c := Collection[D, K]{
+		schema:        schema,
+		serializer:    JSONSerializer[D]{},
+		modifyRetries: DefaultModifyRetries,
+	}
 
 	ApplyAll(&c, opts...)
 
 	return c
 }
 
+// View opens the collection for reading within view. It returns
+// ErrCatalogMismatch when the serializer of the collection cannot read the
+// one recorded in the catalog or it configures an index which is not recorded.
 func (c Collection[D, K]) View(view kv.View) (cv CollectionView[D, K], err error) {
+	recorded, err := c.verify(context.Background(), view.Keyspace, false)
+	if err != nil {
+		return
+	}
+
 	cv.Collection = c
+	cv.enveloped = recorded.Enveloped
 	cv.view, err = view.Keyspace(c.schema.Collection())
+	if err != nil {
+		return
+	}
+
+	cv.indexViews = make([]kv.KeyspaceView, len(c.indexes))
+	for i, idx := range c.indexes {
+		cv.indexViews[i], err = view.Keyspace(indexKeyspace(c.schema.Collection(), idx.Name))
+		if err != nil {
+			return
+		}
+	}
+
 	return
 }
 
+// Init creates the keyspaces of the collection and records it in the catalog.
+// It is safe to call each time the collection is opened: existing keyspaces
+// are retained and the configuration of the collection is validated against
+// its catalog entry, returning ErrCatalogMismatch when the serializer or
+// index definitions differ.
 func (c Collection[D, K]) Init(update kv.Update) error {
-	return update.CreateKeyspace(c.schema.Collection())
+	if err := c.register(context.Background(), update); err != nil {
+		return err
+	}
+
+	for _, keyspace := range c.keyspaces() {
+		if err := createKeyspace(update, keyspace); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
+// Update opens the collection for reading and writing within update. It
+// returns ErrCatalogMismatch when the collection does not match its catalog entry.
 func (c Collection[D, K]) Update(update kv.Update) (cu CollectionUpdate[D, K], err error) {
+	recorded, err := c.verify(context.Background(), func(name []byte) (kv.KeyspaceView, error) {
+		return update.Keyspace(name)
+	}, true)
+	if err != nil {
+		return
+	}
+
 	cu.Collection = c
+	cu.enveloped = recorded.Enveloped
+	cu.tx = update
 	cu.update, err = update.Keyspace(c.schema.Collection())
 	if err != nil {
 		return
 	}
 
-	cu.CollectionView = CollectionView[D, K]{Collection: c, view: cu.update}
+	cu.indexUpdates = make([]kv.KeyspaceUpdate, len(c.indexes))
+	indexViews := make([]kv.KeyspaceView, len(c.indexes))
+	for i, idx := range c.indexes {
+		cu.indexUpdates[i], err = update.Keyspace(indexKeyspace(c.schema.Collection(), idx.Name))
+		if err != nil {
+			return
+		}
+
+		indexViews[i] = cu.indexUpdates[i]
+	}
+
+	constraints := uniqueConstraints(c.schema)
+	cu.uniqueUpdates = make([]kv.KeyspaceUpdate, len(constraints))
+	for i, unique := range constraints {
+		cu.uniqueUpdates[i], err = update.Keyspace(uniqueKeyspace(c.schema.Collection(), unique.Name))
+		if err != nil {
+			return
+		}
+	}
+
+	cu.CollectionView = CollectionView[D, K]{Collection: c, view: cu.update, indexViews: indexViews}
 	return
 }
 
 type CollectionView[D any, K AnyBytes] struct {
 	Collection[D, K]
 
-	view kv.KeyspaceView
+	view       kv.KeyspaceView
+	indexViews []kv.KeyspaceView
+}
+
+// Fetch returns the document stored at key along with its current revision.
+func (c CollectionView[D, K]) Fetch(ctx context.Context, key K) (d D, rev Revision, err error) {
+	return c.fetch(ctx, []byte(key))
 }
 
-func (c CollectionView[D, K]) Fetch(ctx context.Context, key K) (d D, err error) {
-	items, err := c.view.Get(ctx, kv.Key([]byte(key)))
+func (c CollectionView[D, K]) fetch(ctx context.Context, key []byte) (d D, rev Revision, err error) {
+	items, err := c.view.Get(ctx, kv.Key(key))
 	if err != nil {
-		return d, err
+		var berr *kv.BatchError
+		if errors.As(err, &berr) && errors.Is(berr.Errors[0], kv.ErrKeyNotFound) {
+			return d, 0, ErrNotFound
+		}
+
+		return d, 0, err
 	}
 
 	if len(items) == 0 {
-		return d, ErrNotFound
+		return d, 0, ErrNotFound
 	}
 
-	err = c.serializer.Deserialize(items[0].V, &d)
+	err = c.decode(key, items[0].V, &d, nil)
 
-	return
+	return d, Revision(items[0].Version), err
 }
 
-type ListPredicate struct {
-	Offset []byte
-	Limit  int
+// FetchResult is the outcome of fetching a single key with FetchMany.
+// Err is ErrNotFound when no document is stored at the key.
+type FetchResult[D any] struct {
+	Document D
+	Revision Revision
+	Err      error
 }
 
-func (c CollectionView[D, K]) List(ctx context.Context, pred ListPredicate) (ds []D, err error) {
-	items, err := c.view.Range(ctx, kv.Start(pred.Offset), kv.Limit(pred.Limit))
-	if err != nil {
-		return ds, err
+// FetchMany fetches the documents stored at each of keys in as few requests
+// to the backend as its limits allow. The results are positional: the result at index i
+// corresponds to keys[i]. The returned error is only non-nil when the
+// request as a whole fails.
+func (c CollectionView[D, K]) FetchMany(ctx context.Context, keys ...K) ([]FetchResult[D], error) {
+	if len(keys) == 0 {
+		return nil, nil
 	}
 
-	ds = make([]D, len(items))
-	for i := range ds {
-		if err = c.serializer.Deserialize(items[i].V, &ds[i]); err != nil {
+	batch := make([][]byte, len(keys))
+	for i := range keys {
+		batch[i] = []byte(keys[i])
+	}
+
+	items, err := c.view.Get(ctx, kv.Batch(batch...))
+
+	var berr *kv.BatchError
+	if err != nil && !errors.As(err, &berr) {
+		return nil, err
+	}
+
+	results := make([]FetchResult[D], len(keys))
+	for i := range results {
+		if berr != nil && berr.Errors[i] != nil {
+			results[i].Err = berr.Errors[i]
+			if errors.Is(results[i].Err, kv.ErrKeyNotFound) {
+				results[i].Err = ErrNotFound
+			}
+
+			continue
+		}
+
+		results[i].Revision = Revision(items[i].Version)
+		results[i].Err = c.decode(batch[i], items[i].V, &results[i].Document, nil)
+	}
+
+	return results, nil
+}
+
+type ListPredicate[D any] struct {
+	// Offset is the inclusive primary key from which to begin listing.
+	Offset []byte
+	// Token continues a previous List from the page it identifies.
+	// When set it takes precedence over Offset.
+	Token PageToken
+	// Limit is the maximum number of documents in the page.
+	// If Limit < 1, DefaultPageSize is used.
+	// The limit applies to the documents which satisfy Where.
+	Limit int
+	// Where, when not nil, restricts the page to documents for which it returns true.
+	Where func(D) bool
+	// Fields, when set, projects each document onto the named fields.
+	// When the serializer implements FieldDeserializer only those fields are
+	// deserialized and all others are left as their zero value.
+	// Where is applied to the projected document.
+	Fields []string
+}
+
+func (c CollectionView[D, K]) List(ctx context.Context, pred ListPredicate[D]) (page Page[D], err error) {
+	start := pred.Offset
+	if pred.Token != "" {
+		if start, err = pred.Token.start(); err != nil {
 			return
 		}
 	}
 
+	limit := pred.Limit
+	if limit < 1 {
+		limit = DefaultPageSize
+	}
+
+	// request an additional item to determine whether a further page exists
+	batch := limit + 1
+
+	var last []byte
+	for {
+		items, err := c.view.Range(ctx, kv.Start(start), kv.Limit(batch))
+		if err != nil {
+			return page, err
+		}
+
+		for _, item := range items {
+			if len(page.Documents) == limit {
+				// further items remain in range beyond the last document
+				page.Next = newPageToken(last)
+				return page, nil
+			}
+
+			var d D
+			if err := c.decode(item.K, item.V, &d, pred.Fields); err != nil {
+				return page, err
+			}
+
+			if pred.Where != nil && !pred.Where(d) {
+				continue
+			}
+
+			page.Documents = append(page.Documents, d)
+			last = item.K
+		}
+
+		if len(items) < batch {
+			return page, nil
+		}
+
+		// continue from the key immediately following the last key read
+		start = append(append([]byte(nil), items[len(items)-1].K...), 0x00)
+	}
+}
+
+// Iterate calls fn for each document in the collection in primary key order.
+// Documents are read lazily using a kv.Cursor so the collection is walked in
+// constant memory. Iteration stops at the first error returned by fn.
+func (c CollectionView[D, K]) Iterate(ctx context.Context, fn func(D) error) error {
+	return c.iterate(ctx, fn)
+}
+
+// ListPrefix returns every document with a primary key beginning with the
+// tuple of prefix elements. It is intended for collections whose primary
+// keys are composite keyenc tuples, e.g. (tenant, project, id), such that
+// ListPrefix(ctx, keyenc.String(tenant)) returns every document for a tenant.
+func (c CollectionView[D, K]) ListPrefix(ctx context.Context, prefix ...keyenc.Element) (ds []D, err error) {
+	start := []byte(keyenc.Encode(prefix...))
+
+	err = c.iterate(ctx, func(d D) error {
+		ds = append(ds, d)
+		return nil
+	}, kv.Start(start), kv.End(kv.PrefixEnd(start)))
+
 	return
 }
 
+// errStopIteration is returned by an iteration callback to end iteration early without error.
+var errStopIteration = errors.New("stop iteration")
+
+func (c CollectionView[D, K]) iterate(ctx context.Context, fn func(D) error, opts ...kv.RangeOption) error {
+	return c.iterateItems(ctx, func(_ kv.Item, d D) error {
+		return fn(d)
+	}, opts...)
+}
+
+// iterateItems calls fn with each item in range and its deserialized document.
+// Returning errStopIteration from fn ends iteration without error.
+func (c CollectionView[D, K]) iterateItems(ctx context.Context, fn func(kv.Item, D) error, opts ...kv.RangeOption) error {
+	cursor, err := c.view.Cursor(ctx, opts...)
+	if err != nil {
+		return err
+	}
+
+	defer cursor.Close()
+
+	for cursor.Next() {
+		item := cursor.Item()
+
+		var d D
+		if err := c.decode(item.K, item.V, &d, nil); err != nil {
+			return err
+		}
+
+		if err := fn(item, d); err != nil {
+			if errors.Is(err, errStopIteration) {
+				return nil
+			}
+
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
 type CollectionUpdate[D any, K AnyBytes] struct {
 	CollectionView[D, K]
 
-	update kv.KeyspaceUpdate
+	tx            kv.Update
+	update        kv.KeyspaceUpdate
+	indexUpdates  []kv.KeyspaceUpdate
+	uniqueUpdates []kv.KeyspaceUpdate
 }
 
-func (c CollectionUpdate[D, K]) Fetch(ctx context.Context, key K) (d D, err error) {
+func (c CollectionUpdate[D, K]) Fetch(ctx context.Context, key K) (d D, rev Revision, err error) {
 	return c.CollectionView.Fetch(ctx, key)
 }
 
-func (c CollectionUpdate[D, K]) List(ctx context.Context, pred ListPredicate) ([]D, error) {
+func (c CollectionUpdate[D, K]) List(ctx context.Context, pred ListPredicate[D]) (Page[D], error) {
 	return c.CollectionView.List(ctx, pred)
 }
 
 func (c CollectionUpdate[D, K]) Put(ctx context.Context, doc D) error {
-	v, err := c.serializer.Serialize(doc)
+	return c.put(ctx, doc)
+}
+
+// PutIfVersion puts the document only if the revision of the stored
+// document is rev, as returned by Fetch. A rev of zero requires that no
+// document is currently stored. Otherwise, ErrConflict is returned.
+func (c CollectionUpdate[D, K]) PutIfVersion(ctx context.Context, doc D, rev Revision) error {
+	return c.put(ctx, doc, kv.IfVersion(int64(rev)))
+}
+
+// Insert puts the document only if no document is stored with the same
+// primary key. Otherwise, ErrAlreadyExists is returned.
+func (c CollectionUpdate[D, K]) Insert(ctx context.Context, doc D) error {
+	return c.put(ctx, doc, kv.IfAbsent())
+}
+
+// Replace puts the document only if a document is already stored with
+// the same primary key. Otherwise, ErrNotFound is returned.
+func (c CollectionUpdate[D, K]) Replace(ctx context.Context, doc D) error {
+	return c.put(ctx, doc, kv.IfPresent())
+}
+
+// Modify atomically reads, mutates and writes back the document stored at key.
+// The write is conditional on the revision read, so on backends which permit
+// concurrent writers (e.g. etcd) a conflicting write causes the document to be
+// re-read and fn to be applied again, up to the configured number of retries
+// (see WithModifyRetries), after which ErrConflict is returned.
+// Modify returns ErrNotFound when no document is stored at key.
+func (c CollectionUpdate[D, K]) Modify(ctx context.Context, key K, fn func(*D) error) error {
+	for attempt := 0; ; attempt++ {
+		doc, rev, err := c.fetch(ctx, []byte(key))
+		if err != nil {
+			return err
+		}
+
+		if err := fn(&doc); err != nil {
+			return err
+		}
+
+		if !bytes.Equal(c.schema.PrimaryKey(doc), []byte(key)) {
+			return ErrPrimaryKeyChanged
+		}
+
+		err = c.put(ctx, doc, kv.IfVersion(int64(rev)))
+		if !errors.Is(err, ErrConflict) || attempt >= c.modifyRetries {
+			return err
+		}
+	}
+}
+
+func (c CollectionUpdate[D, K]) put(ctx context.Context, doc D, opts ...kv.PutOption) error {
+	key := c.schema.PrimaryKey(doc)
+
+	old, written, err := c.previous(ctx, key)
 	if err != nil {
 		return err
 	}
 
-	return c.update.Put(ctx, c.schema.PrimaryKey(doc), v)
+	if err := c.runHooks(ctx, c.hooks.beforePut, old, &doc); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(c.schema.PrimaryKey(doc), key) {
+		return ErrPrimaryKeyChanged
+	}
+
+	if err := c.validate(doc); err != nil {
+		return err
+	}
+
+	v, err := c.encode(key, doc)
+	if err != nil {
+		return err
+	}
+
+	if err := c.checkUnique(ctx, key, doc); err != nil {
+		return err
+	}
+
+	changes := c.newDerivedChanges()
+	c.derive(changes, key, written, &doc)
+
+	undo, err := c.applyUnique(ctx, changes)
+	if err != nil {
+		return err
+	}
+
+	if err := c.update.Put(ctx, key, v, opts...); err != nil {
+		undo()
+		return putError(err)
+	}
+
+	if err := c.settleDerived(ctx, changes); err != nil {
+		return err
+	}
+
+	return c.runHooks(ctx, c.hooks.afterPut, old, &doc)
 }
 
 func (c CollectionUpdate[D, K]) Delete(ctx context.Context, doc D) error {
-	return c.update.Delete(ctx, c.schema.PrimaryKey(doc))
+	key := c.schema.PrimaryKey(doc)
+
+	old, written, err := c.previous(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if old != nil {
+		if err := c.runHooks(ctx, c.hooks.beforeDelete, old, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := c.update.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	if err := c.updateDerived(ctx, key, written, nil); err != nil {
+		return err
+	}
+
+	if old == nil {
+		return nil
+	}
+
+	return c.runHooks(ctx, c.hooks.afterDelete, old, nil)
+}
+
+// putError translates the errors returned by conditional puts
+// into their collection equivalents.
+func putError(err error) error {
+	switch {
+	case errors.Is(err, kv.ErrConflict):
+		return ErrConflict
+	case errors.Is(err, kv.ErrKeyExists):
+		return ErrAlreadyExists
+	case errors.Is(err, kv.ErrKeyNotFound):
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// previous returns the currently stored document for key when the
+// collection has derived state (e.g. indexes) or hooks which depend on it,
+// along with the document as written from which its derived state was
+// computed (see written). They are nil when there is no stored document
+// or nothing depends on it.
+func (c CollectionUpdate[D, K]) previous(ctx context.Context, key []byte) (old, written *D, err error) {
+	if !c.needsPrevious() {
+		return nil, nil, nil
+	}
+
+	items, err := c.view.Get(ctx, kv.Key(key))
+	if err != nil {
+		var berr *kv.BatchError
+		if errors.As(err, &berr) && errors.Is(berr.Errors[0], kv.ErrKeyNotFound) {
+			return nil, nil, nil
+		}
+
+		return nil, nil, err
+	}
+
+	return c.stored(key, items[0].V)
+}
+
+// stored decodes the value stored at key into the document at the current
+// version and the document as written.
+func (c Collection[D, K]) stored(key, v []byte) (old, written *D, err error) {
+	env, err := decodeEnvelope(v, c.enveloped)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var d D
+	if err := c.decode(key, v, &d, nil); err != nil {
+		return nil, nil, err
+	}
+
+	return &d, c.written(key, env, &d), nil
 }
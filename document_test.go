@@ -0,0 +1,2352 @@
+package dokvs_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/georgemac/dokvs"
+	"github.com/georgemac/dokvs/pkg/keyenc"
+	"github.com/georgemac/dokvs/pkg/kv"
+	"github.com/georgemac/dokvs/pkg/kv/boltdb"
+	"github.com/georgemac/dokvs/pkg/kv/etcd"
+	"github.com/georgemac/dokvs/pkg/query"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/tests/v3/integration"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type Book struct {
+	ID     string `json:"id"`
+	Author string `json:"author"`
+}
+
+var books = dokvs.NewSchema("books", func(b Book) []byte {
+	return []byte(b.ID)
+})
+
+func byAuthor(b Book) []byte {
+	return []byte(b.Author)
+}
+
+func TestCollection_Index(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx        = context.Background()
+			collection = dokvs.NewCollection[Book, string](books, dokvs.WithIndex[Book, string]("by_author", byAuthor))
+		)
+
+		update(t, store, collection.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			books, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			for _, book := range []Book{
+				{ID: "1", Author: "george"},
+				{ID: "2", Author: "ada"},
+				{ID: "3", Author: "george"},
+				{ID: "4", Author: "a"},
+				{ID: "5", Author: "a\x00b"},
+			} {
+				require.NoError(t, books.Put(ctx, book))
+			}
+
+			// move book 3 to a different author
+			require.NoError(t, books.Put(ctx, Book{ID: "3", Author: "grace"}))
+			// remove book 2 entirely
+			require.NoError(t, books.Delete(ctx, Book{ID: "2"}))
+
+			return nil
+		})
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			books, err := collection.View(tx)
+			require.NoError(t, err)
+
+			for author, expected := range map[string][]Book{
+				"george": {{ID: "1", Author: "george"}},
+				"grace":  {{ID: "3", Author: "grace"}},
+				"ada":    nil,
+				// the encoding of a value must not be a prefix of one which extends it
+				"a":      {{ID: "4", Author: "a"}},
+				"a\x00b": {{ID: "5", Author: "a\x00b"}},
+			} {
+				found, err := books.Lookup(ctx, "by_author", []byte(author))
+				require.NoError(t, err)
+				assert.Equal(t, expected, found, author)
+			}
+
+			_, err = books.Lookup(ctx, "by_title", []byte("missing"))
+			assert.ErrorIs(t, err, dokvs.ErrIndexNotFound)
+
+			return nil
+		}))
+
+		// entries which outlive their document are skipped, and lookups
+		// exceeding the operations permitted in an etcd transaction are split
+		var prolific []Book
+		for i := 0; i < 500; i++ {
+			prolific = append(prolific, Book{ID: "prolific-" + strconv.Itoa(1000+i), Author: "prolific"})
+		}
+
+		update(t, store, func(tx kv.Update) error {
+			books, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			require.NoError(t, books.PutMany(ctx, prolific...))
+
+			documents, err := tx.Keyspace([]byte("books"))
+			require.NoError(t, err)
+
+			return documents.Delete(ctx, []byte(prolific[0].ID))
+		})
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			books, err := collection.View(tx)
+			require.NoError(t, err)
+
+			found, err := books.Lookup(ctx, "by_author", []byte("prolific"))
+			require.NoError(t, err)
+			assert.Equal(t, prolific[1:], found)
+
+			return nil
+		}))
+	})
+}
+
+type User struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+}
+
+var users = dokvs.NewSchema(
+	"users",
+	func(u User) []byte { return []byte(u.ID) },
+	dokvs.WithUnique("email", func(u User) []byte { return []byte(u.Email) }),
+)
+
+func TestCollection_Unique(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx        = context.Background()
+			collection = dokvs.NewCollection[User, string](users)
+		)
+
+		update(t, store, collection.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			users, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			require.NoError(t, users.Put(ctx, User{ID: "1", Email: "george@example.com"}))
+			// re-putting the same document does not violate its own constraint
+			require.NoError(t, users.Put(ctx, User{ID: "1", Email: "george@example.com"}))
+
+			err = users.Put(ctx, User{ID: "2", Email: "george@example.com"})
+			assert.Equal(t, &dokvs.ErrUniqueViolation{Constraint: "email", Key: []byte("1")}, err)
+
+			// changing the email of user 1 releases the original value
+			require.NoError(t, users.Put(ctx, User{ID: "1", Email: "g@example.com"}))
+			require.NoError(t, users.Put(ctx, User{ID: "2", Email: "george@example.com"}))
+
+			// deleting user 2 releases its value
+			require.NoError(t, users.Delete(ctx, User{ID: "2"}))
+			require.NoError(t, users.Put(ctx, User{ID: "3", Email: "george@example.com"}))
+
+			var violation *dokvs.ErrUniqueViolation
+			err = users.Put(ctx, User{ID: "4", Email: "g@example.com"})
+			require.ErrorAs(t, err, &violation)
+			assert.Equal(t, []byte("1"), violation.Key)
+
+			_, _, err = users.Fetch(ctx, "4")
+			assert.ErrorIs(t, err, dokvs.ErrNotFound)
+
+			return nil
+		})
+
+		// concurrent writers of the same value in separate updates must not
+		// both succeed, including on etcd where updates are not transactional
+		var (
+			wg   sync.WaitGroup
+			errs = make([]error, 8)
+		)
+
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				errs[i] = store.Update(func(tx kv.Update) error {
+					users, err := collection.Update(tx)
+					if err != nil {
+						return err
+					}
+
+					return users.Put(ctx, User{ID: "racer" + strconv.Itoa(i), Email: "race@example.com"})
+				})
+			}(i)
+		}
+
+		wg.Wait()
+
+		var succeeded int
+		for _, err := range errs {
+			var violation *dokvs.ErrUniqueViolation
+			if err == nil {
+				succeeded++
+			} else {
+				assert.ErrorAs(t, err, &violation)
+			}
+		}
+
+		assert.Equal(t, 1, succeeded)
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			users, err := collection.View(tx)
+			require.NoError(t, err)
+
+			page, err := users.List(ctx, dokvs.ListPredicate[User]{})
+			require.NoError(t, err)
+
+			var holders int
+			for _, user := range page.Documents {
+				if user.Email == "race@example.com" {
+					holders++
+				}
+			}
+
+			assert.Equal(t, 1, holders)
+
+			return nil
+		}))
+	})
+}
+
+// interceptStore calls before ahead of each put to the named keyspace.
+type interceptStore struct {
+	kv.Store
+	keyspace string
+	before   func(tx kv.Update)
+}
+
+func (s interceptStore) Update(fn func(kv.Update) error) error {
+	return s.Store.Update(func(tx kv.Update) error {
+		return fn(interceptUpdate{Update: tx, store: s})
+	})
+}
+
+type interceptUpdate struct {
+	kv.Update
+	store interceptStore
+}
+
+func (u interceptUpdate) Keyspace(name []byte) (kv.KeyspaceUpdate, error) {
+	ks, err := u.Update.Keyspace(name)
+	if err != nil || string(name) != u.store.keyspace {
+		return ks, err
+	}
+
+	return interceptKeyspace{KeyspaceUpdate: ks, before: func() { u.store.before(u.Update) }}, nil
+}
+
+type interceptKeyspace struct {
+	kv.KeyspaceUpdate
+	before func()
+}
+
+func (k interceptKeyspace) Put(ctx context.Context, key, value []byte, opts ...kv.PutOption) error {
+	k.before()
+	return k.KeyspaceUpdate.Put(ctx, key, value, opts...)
+}
+
+func TestCollection_UniqueFailedWrite(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx        = context.Background()
+			collection = dokvs.NewCollection[User, string](users)
+			contender  error
+			contended  bool
+		)
+
+		update(t, store, collection.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			users, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			return users.Put(ctx, User{ID: "1", Email: "a@example.com"})
+		})
+
+		// another writer attempts to claim the value held by user 1 while
+		// the write of user 1 which would release it is in progress
+		intercepted := interceptStore{Store: store, keyspace: "users", before: func(tx kv.Update) {
+			if contended {
+				return
+			}
+
+			contended = true
+
+			users, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			contender = users.Put(ctx, User{ID: "2", Email: "a@example.com"})
+		}}
+
+		require.NoError(t, intercepted.Update(func(tx kv.Update) error {
+			users, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			_, rev, err := users.Fetch(ctx, "1")
+			require.NoError(t, err)
+
+			err = users.PutIfVersion(ctx, User{ID: "1", Email: "b@example.com"}, rev+1)
+			assert.ErrorIs(t, err, dokvs.ErrConflict)
+
+			return nil
+		}))
+
+		require.True(t, contended)
+		assert.Equal(t, &dokvs.ErrUniqueViolation{Constraint: "email", Key: []byte("1")}, contender)
+
+		update(t, store, func(tx kv.Update) error {
+			users, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			_, _, err = users.Fetch(ctx, "2")
+			assert.ErrorIs(t, err, dokvs.ErrNotFound)
+
+			// the value claimed by the failed write is released
+			require.NoError(t, users.Put(ctx, User{ID: "3", Email: "b@example.com"}))
+
+			err = users.Put(ctx, User{ID: "4", Email: "a@example.com"})
+			assert.Equal(t, &dokvs.ErrUniqueViolation{Constraint: "email", Key: []byte("1")}, err)
+
+			return nil
+		})
+	})
+}
+
+// plainSchema implements only CollectionSchema and declares no unique constraints.
+type plainSchema struct{}
+
+func (plainSchema) Collection() []byte        { return []byte("plain") }
+func (plainSchema) PrimaryKey(b Book) []byte  { return []byte(b.ID) }
+func (plainSchema) Version() uint64           { return 1 }
+func (plainSchema) Upgrades() []dokvs.Upgrade { return nil }
+
+func TestCollection_CustomSchema(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx        = context.Background()
+			collection = dokvs.NewCollection[Book, string](plainSchema{})
+		)
+
+		update(t, store, collection.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			books, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			require.NoError(t, books.Put(ctx, Book{ID: "1", Author: "ada"}))
+
+			found, _, err := books.Fetch(ctx, "1")
+			require.NoError(t, err)
+			assert.Equal(t, Book{ID: "1", Author: "ada"}, found)
+
+			return nil
+		})
+	})
+}
+
+func TestCollection_List_Pagination(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx        = context.Background()
+			collection = dokvs.NewCollection[Book, string](books)
+		)
+
+		update(t, store, collection.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			books, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			for _, id := range []string{"a", "b", "c", "d", "e"} {
+				require.NoError(t, books.Put(ctx, Book{ID: id}))
+			}
+
+			return nil
+		})
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			books, err := collection.View(tx)
+			require.NoError(t, err)
+
+			var (
+				pages [][]Book
+				pred  = dokvs.ListPredicate[Book]{Limit: 2}
+			)
+
+			for {
+				page, err := books.List(ctx, pred)
+				require.NoError(t, err)
+
+				pages = append(pages, page.Documents)
+				if page.Next == "" {
+					break
+				}
+
+				pred.Token = page.Next
+			}
+
+			assert.Equal(t, [][]Book{
+				{{ID: "a"}, {ID: "b"}},
+				{{ID: "c"}, {ID: "d"}},
+				{{ID: "e"}},
+			}, pages)
+
+			var iterated []Book
+			require.NoError(t, books.Iterate(ctx, func(b Book) error {
+				iterated = append(iterated, b)
+				return nil
+			}))
+			assert.Len(t, iterated, 5)
+
+			_, err = books.List(ctx, dokvs.ListPredicate[Book]{Token: "!"})
+			assert.ErrorIs(t, err, dokvs.ErrInvalidPageToken)
+
+			return nil
+		}))
+	})
+}
+
+type Event struct {
+	Seq int64 `json:"seq"`
+}
+
+var events = dokvs.NewSchema("events", func(e Event) keyenc.Key {
+	return keyenc.Encode(keyenc.Int64(e.Seq))
+})
+
+func TestCollection_TypedKeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx        = context.Background()
+			collection = dokvs.NewCollection[Event, keyenc.Key](events)
+		)
+
+		update(t, store, collection.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			events, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			for _, seq := range []int64{10, -5, 3, -100, 256} {
+				require.NoError(t, events.Put(ctx, Event{Seq: seq}))
+			}
+
+			return nil
+		})
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			events, err := collection.View(tx)
+			require.NoError(t, err)
+
+			page, err := events.List(ctx, dokvs.ListPredicate[Event]{})
+			require.NoError(t, err)
+
+			assert.Equal(t, []Event{{-100}, {-5}, {3}, {10}, {256}}, page.Documents)
+
+			event, _, err := events.Fetch(ctx, keyenc.Encode(keyenc.Int64(-5)))
+			require.NoError(t, err)
+			assert.Equal(t, Event{-5}, event)
+
+			return nil
+		}))
+	})
+}
+
+type Task struct {
+	Tenant  string `json:"tenant"`
+	Project string `json:"project"`
+	ID      int64  `json:"id"`
+}
+
+var tasks = dokvs.NewSchema("tasks", func(t Task) keyenc.Key {
+	return keyenc.Encode(keyenc.String(t.Tenant), keyenc.String(t.Project), keyenc.Int64(t.ID))
+})
+
+func TestCollection_ListPrefix(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx        = context.Background()
+			collection = dokvs.NewCollection[Task, keyenc.Key](tasks)
+		)
+
+		update(t, store, collection.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			tasks, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			for _, task := range []Task{
+				{"acme", "rockets", 2},
+				{"acme", "rockets", 1},
+				{"acme", "anvils", 1},
+				{"acme2", "rockets", 1},
+				{"acme\x00x", "rockets", 1},
+				{"globex", "rockets", 1},
+			} {
+				require.NoError(t, tasks.Put(ctx, task))
+			}
+
+			return nil
+		})
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			tasks, err := collection.View(tx)
+			require.NoError(t, err)
+
+			found, err := tasks.ListPrefix(ctx, keyenc.String("acme"))
+			require.NoError(t, err)
+			assert.Equal(t, []Task{
+				{"acme", "anvils", 1},
+				{"acme", "rockets", 1},
+				{"acme", "rockets", 2},
+			}, found)
+
+			found, err = tasks.ListPrefix(ctx, keyenc.String("acme"), keyenc.String("rockets"))
+			require.NoError(t, err)
+			assert.Equal(t, []Task{
+				{"acme", "rockets", 1},
+				{"acme", "rockets", 2},
+			}, found)
+
+			found, err = tasks.ListPrefix(ctx, keyenc.String("initech"))
+			require.NoError(t, err)
+			assert.Empty(t, found)
+
+			found, err = tasks.ListPrefix(ctx, keyenc.String("acme\x00x"))
+			require.NoError(t, err)
+			assert.Equal(t, []Task{{"acme\x00x", "rockets", 1}}, found)
+
+			found, err = tasks.ListPrefix(ctx)
+			require.NoError(t, err)
+			assert.Len(t, found, 6)
+
+			return nil
+		}))
+	})
+}
+
+func TestCollection_PutIfVersion(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx        = context.Background()
+			collection = dokvs.NewCollection[Book, string](books)
+		)
+
+		update(t, store, collection.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			books, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			require.NoError(t, books.PutIfVersion(ctx, Book{ID: "1", Author: "george"}, 0))
+			assert.Equal(t, dokvs.ErrConflict, books.PutIfVersion(ctx, Book{ID: "1", Author: "ada"}, 0))
+
+			book, rev, err := books.Fetch(ctx, "1")
+			require.NoError(t, err)
+			assert.Equal(t, Book{ID: "1", Author: "george"}, book)
+			assert.NotZero(t, rev)
+
+			// another writer modifies the document
+			require.NoError(t, books.Put(ctx, Book{ID: "1", Author: "grace"}))
+
+			assert.Equal(t, dokvs.ErrConflict, books.PutIfVersion(ctx, Book{ID: "1", Author: "ada"}, rev))
+
+			book, rev, err = books.Fetch(ctx, "1")
+			require.NoError(t, err)
+			assert.Equal(t, Book{ID: "1", Author: "grace"}, book)
+
+			require.NoError(t, books.PutIfVersion(ctx, Book{ID: "1", Author: "ada"}, rev))
+
+			return nil
+		})
+	})
+}
+
+func TestCollection_InsertReplace(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx        = context.Background()
+			collection = dokvs.NewCollection[Book, string](books)
+		)
+
+		update(t, store, collection.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			books, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			assert.Equal(t, dokvs.ErrNotFound, books.Replace(ctx, Book{ID: "1", Author: "ada"}))
+
+			require.NoError(t, books.Insert(ctx, Book{ID: "1", Author: "george"}))
+			assert.Equal(t, dokvs.ErrAlreadyExists, books.Insert(ctx, Book{ID: "1", Author: "grace"}))
+
+			require.NoError(t, books.Replace(ctx, Book{ID: "1", Author: "ada"}))
+
+			book, _, err := books.Fetch(ctx, "1")
+			require.NoError(t, err)
+			assert.Equal(t, Book{ID: "1", Author: "ada"}, book)
+
+			return nil
+		})
+	})
+}
+
+func TestCollection_Modify(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx        = context.Background()
+			collection = dokvs.NewCollection[Book, string](books, dokvs.WithModifyRetries[Book, string](2))
+		)
+
+		update(t, store, collection.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			books, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			assert.Equal(t, dokvs.ErrNotFound, books.Modify(ctx, "1", func(*Book) error { return nil }))
+
+			require.NoError(t, books.Put(ctx, Book{ID: "1", Author: "george"}))
+
+			t.Run("retries after a conflicting write", func(t *testing.T) {
+				var attempts int
+				require.NoError(t, books.Modify(ctx, "1", func(b *Book) error {
+					attempts++
+					if attempts == 1 {
+						// simulate a concurrent writer between read and write
+						require.NoError(t, books.Put(ctx, Book{ID: "1", Author: "grace"}))
+					}
+
+					b.Author += "!"
+					return nil
+				}))
+
+				assert.Equal(t, 2, attempts)
+
+				book, _, err := books.Fetch(ctx, "1")
+				require.NoError(t, err)
+				assert.Equal(t, Book{ID: "1", Author: "grace!"}, book)
+			})
+
+			t.Run("gives up after the configured retries", func(t *testing.T) {
+				var attempts int
+				err := books.Modify(ctx, "1", func(b *Book) error {
+					attempts++
+					require.NoError(t, books.Put(ctx, Book{ID: "1", Author: "ada"}))
+					return nil
+				})
+
+				assert.Equal(t, dokvs.ErrConflict, err)
+				assert.Equal(t, 3, attempts)
+			})
+
+			t.Run("rejects primary key changes", func(t *testing.T) {
+				err := books.Modify(ctx, "1", func(b *Book) error {
+					b.ID = "2"
+					return nil
+				})
+
+				assert.Equal(t, dokvs.ErrPrimaryKeyChanged, err)
+			})
+
+			return nil
+		})
+	})
+}
+
+func TestCollection_FetchMany(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx        = context.Background()
+			collection = dokvs.NewCollection[Book, string](books)
+		)
+
+		update(t, store, collection.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			books, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			require.NoError(t, books.Put(ctx, Book{ID: "1", Author: "george"}))
+			require.NoError(t, books.Put(ctx, Book{ID: "3", Author: "grace"}))
+
+			return nil
+		})
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			books, err := collection.View(tx)
+			require.NoError(t, err)
+
+			results, err := books.FetchMany(ctx, "1", "2", "3")
+			require.NoError(t, err)
+			require.Len(t, results, 3)
+
+			assert.Equal(t, Book{ID: "1", Author: "george"}, results[0].Document)
+			assert.NoError(t, results[0].Err)
+			assert.NotZero(t, results[0].Revision)
+
+			assert.Equal(t, dokvs.ErrNotFound, results[1].Err)
+
+			assert.Equal(t, Book{ID: "3", Author: "grace"}, results[2].Document)
+			assert.NoError(t, results[2].Err)
+
+			return nil
+		}))
+
+		// fetches exceeding the operations permitted in an etcd transaction
+		// are split while their results remain in the order requested
+		var (
+			stored []Book
+			keys   []string
+		)
+
+		for i := 0; i < 200; i++ {
+			id := "many-" + strconv.Itoa(i)
+			keys = append(keys, id)
+
+			if i%4 != 0 {
+				stored = append(stored, Book{ID: id, Author: "ada"})
+			}
+		}
+
+		update(t, store, func(tx kv.Update) error {
+			books, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			return books.PutMany(ctx, stored...)
+		})
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			books, err := collection.View(tx)
+			require.NoError(t, err)
+
+			results, err := books.FetchMany(ctx, keys...)
+			require.NoError(t, err)
+			require.Len(t, results, len(keys))
+
+			for i, result := range results {
+				if i%4 == 0 {
+					assert.Equal(t, dokvs.ErrNotFound, result.Err)
+					continue
+				}
+
+				require.NoError(t, result.Err)
+				assert.Equal(t, Book{ID: keys[i], Author: "ada"}, result.Document)
+			}
+
+			return nil
+		}))
+	})
+}
+
+func TestCollection_PutManyDeleteMany(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx        = context.Background()
+			collection = dokvs.NewCollection[User, string](users)
+		)
+
+		update(t, store, collection.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			users, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			require.NoError(t, users.PutMany(ctx,
+				User{ID: "1", Email: "a@example.com"},
+				User{ID: "2", Email: "b@example.com"},
+				User{ID: "3", Email: "c@example.com"},
+			))
+
+			// users 1 and 2 swap email addresses within a single batch
+			require.NoError(t, users.PutMany(ctx,
+				User{ID: "1", Email: "b@example.com"},
+				User{ID: "2", Email: "a@example.com"},
+			))
+
+			err = users.PutMany(ctx,
+				User{ID: "4", Email: "d@example.com"},
+				User{ID: "5", Email: "d@example.com"},
+			)
+			assert.Equal(t, &dokvs.ErrUniqueViolation{Constraint: "email", Key: []byte("4")}, err)
+
+			err = users.PutMany(ctx, User{ID: "4", Email: "c@example.com"})
+			assert.Equal(t, &dokvs.ErrUniqueViolation{Constraint: "email", Key: []byte("3")}, err)
+
+			require.NoError(t, users.DeleteMany(ctx, User{ID: "3"}, User{ID: "missing"}))
+
+			// the value released by user 3 can now be claimed
+			require.NoError(t, users.Put(ctx, User{ID: "4", Email: "c@example.com"}))
+
+			page, err := users.List(ctx, dokvs.ListPredicate[User]{})
+			require.NoError(t, err)
+			assert.Equal(t, []User{
+				{ID: "1", Email: "b@example.com"},
+				{ID: "2", Email: "a@example.com"},
+				{ID: "4", Email: "c@example.com"},
+			}, page.Documents)
+
+			return nil
+		})
+	})
+}
+
+func TestCollection_PutManyDeleteMany_Large(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx        = context.Background()
+			puts       int
+			collection = dokvs.NewCollection[User, string](users,
+				dokvs.WithIndex[User, string]("by_email", byEmail),
+				dokvs.WithAfterPut[User, string](func(context.Context, kv.Update, *User, *User) error {
+					puts++
+					return nil
+				}))
+			docs = make([]User, 500)
+		)
+
+		for i := range docs {
+			docs[i] = User{ID: strconv.Itoa(i), Email: strconv.Itoa(i) + "@example.com"}
+		}
+
+		update(t, store, collection.Init)
+
+		// batches exceeding the operations permitted in an etcd transaction
+		// read their previous documents and unique claims in several requests
+		update(t, store, func(tx kv.Update) error {
+			users, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			require.NoError(t, users.PutMany(ctx, docs...))
+
+			for i := range docs {
+				docs[i].Email = "renamed-" + docs[i].Email
+			}
+
+			return users.PutMany(ctx, docs...)
+		})
+
+		assert.Equal(t, 2*len(docs), puts)
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			users, err := collection.View(tx)
+			require.NoError(t, err)
+
+			found, err := users.Lookup(ctx, "by_email", []byte("renamed-499@example.com"))
+			require.NoError(t, err)
+			assert.Equal(t, []User{docs[499]}, found)
+
+			found, err = users.Lookup(ctx, "by_email", []byte("499@example.com"))
+			require.NoError(t, err)
+			assert.Empty(t, found)
+
+			return nil
+		}))
+
+		update(t, store, func(tx kv.Update) error {
+			users, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			return users.DeleteMany(ctx, docs...)
+		})
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			for _, keyspace := range []string{"users", "users:index:by_email", "users:unique:email"} {
+				ks, err := tx.Keyspace([]byte(keyspace))
+				require.NoError(t, err)
+
+				n, err := ks.Count(ctx)
+				require.NoError(t, err)
+				assert.Zero(t, n, keyspace)
+			}
+
+			return nil
+		}))
+	})
+}
+
+func TestCollection_DeleteKeyRangeWhere(t *testing.T) {
+	for name, opts := range map[string][]func(*dokvs.Collection[Book, string]){
+		"without indexes": nil,
+		"with indexes":    {dokvs.WithIndex[Book, string]("by_author", byAuthor)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			forEachStore(t, func(t *testing.T, store kv.Store) {
+				var (
+					ctx        = context.Background()
+					collection = dokvs.NewCollection(books, opts...)
+				)
+
+				update(t, store, collection.Init)
+
+				update(t, store, func(tx kv.Update) error {
+					books, err := collection.Update(tx)
+					require.NoError(t, err)
+
+					require.NoError(t, books.PutMany(ctx,
+						Book{ID: "a1", Author: "george"},
+						Book{ID: "a2", Author: "ada"},
+						Book{ID: "b1", Author: "george"},
+						Book{ID: "b2", Author: "grace"},
+						Book{ID: "c1", Author: "george"},
+						Book{ID: "c2", Author: "ada"},
+					))
+
+					deleted, err := books.DeleteKey(ctx, "a1")
+					require.NoError(t, err)
+					assert.True(t, deleted)
+
+					deleted, err = books.DeleteKey(ctx, "a1")
+					require.NoError(t, err)
+					assert.False(t, deleted)
+
+					n, err := books.DeleteRange(ctx, kv.Prefix([]byte("b")))
+					require.NoError(t, err)
+					assert.Equal(t, 2, n)
+
+					n, err = books.DeleteWhere(ctx, func(b Book) bool { return b.Author == "ada" })
+					require.NoError(t, err)
+					assert.Equal(t, 2, n)
+
+					page, err := books.List(ctx, dokvs.ListPredicate[Book]{})
+					require.NoError(t, err)
+					assert.Equal(t, []Book{{ID: "c1", Author: "george"}}, page.Documents)
+
+					if name == "with indexes" {
+						found, err := books.Lookup(ctx, "by_author", []byte("george"))
+						require.NoError(t, err)
+						assert.Equal(t, []Book{{ID: "c1", Author: "george"}}, found)
+					}
+
+					return nil
+				})
+			})
+		})
+	}
+}
+
+func TestCollection_List_WhereAndFields(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx        = context.Background()
+			collection = dokvs.NewCollection[Book, string](books)
+		)
+
+		update(t, store, collection.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			books, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			for i, author := range []string{"george", "ada", "ada", "grace", "george", "ada", "george", "george"} {
+				require.NoError(t, books.Put(ctx, Book{ID: string(rune('a' + i)), Author: author}))
+			}
+
+			return nil
+		})
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			books, err := collection.View(tx)
+			require.NoError(t, err)
+
+			var (
+				pages [][]Book
+				pred  = dokvs.ListPredicate[Book]{
+					Limit: 2,
+					Where: func(b Book) bool { return b.Author == "george" },
+				}
+			)
+
+			for {
+				page, err := books.List(ctx, pred)
+				require.NoError(t, err)
+
+				pages = append(pages, page.Documents)
+				if page.Next == "" {
+					break
+				}
+
+				pred.Token = page.Next
+			}
+
+			assert.Equal(t, [][]Book{
+				{{ID: "a", Author: "george"}, {ID: "e", Author: "george"}},
+				{{ID: "g", Author: "george"}, {ID: "h", Author: "george"}},
+			}, pages)
+
+			page, err := books.List(ctx, dokvs.ListPredicate[Book]{Limit: 3, Fields: []string{"id"}})
+			require.NoError(t, err)
+			assert.Equal(t, []Book{{ID: "a"}, {ID: "b"}, {ID: "c"}}, page.Documents)
+
+			return nil
+		}))
+	})
+}
+
+type Review struct {
+	ID      string `json:"id"`
+	Author  string `json:"author"`
+	Rating  int    `json:"rating"`
+	Created int64  `json:"created"`
+}
+
+var reviews = dokvs.NewSchema("reviews", func(r Review) []byte {
+	return []byte(r.ID)
+})
+
+func TestCollection_Query(t *testing.T) {
+	for name, opts := range map[string][]func(*dokvs.Collection[Review, string]){
+		"scan":        nil,
+		"field index": {dokvs.WithFieldIndex[Review, string]("author")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			forEachStore(t, func(t *testing.T, store kv.Store) {
+				var (
+					ctx        = context.Background()
+					collection = dokvs.NewCollection(reviews, opts...)
+				)
+
+				update(t, store, collection.Init)
+
+				update(t, store, func(tx kv.Update) error {
+					reviews, err := collection.Update(tx)
+					require.NoError(t, err)
+
+					return reviews.PutMany(ctx,
+						Review{ID: "a", Author: "bob", Rating: 5, Created: 1},
+						Review{ID: "b", Author: "bob", Rating: 3, Created: 2},
+						Review{ID: "c", Author: "alice", Rating: 5, Created: 3},
+						Review{ID: "d", Author: "bob", Rating: 4, Created: 4},
+						Review{ID: "e", Author: "eve", Rating: 1, Created: 5},
+						Review{ID: "f", Author: "bob", Rating: 4, Created: 0},
+					)
+				})
+
+				require.NoError(t, store.View(func(tx kv.View) error {
+					reviews, err := collection.View(tx)
+					require.NoError(t, err)
+
+					found, err := reviews.Query(ctx, `author = "bob" AND rating >= 4 ORDER BY created DESC LIMIT 20`)
+					require.NoError(t, err)
+					assert.Equal(t, []string{"d", "a", "f"}, reviewIDs(found))
+
+					found, err = reviews.Query(ctx, `(author = "bob" and rating >= 4) or author = "eve" order by created desc limit 3`)
+					require.NoError(t, err)
+					assert.Equal(t, []string{"e", "d", "a"}, reviewIDs(found))
+
+					found, err = reviews.Query(ctx, `NOT author = "bob" LIMIT 1`)
+					require.NoError(t, err)
+					assert.Equal(t, []string{"c"}, reviewIDs(found))
+
+					found, err = reviews.Query(ctx, `ORDER BY rating, created DESC`)
+					require.NoError(t, err)
+					assert.Equal(t, []string{"e", "b", "d", "f", "c", "a"}, reviewIDs(found))
+
+					_, err = reviews.Query(ctx, `author = `)
+					var syntaxErr *query.SyntaxError
+					assert.ErrorAs(t, err, &syntaxErr)
+
+					return nil
+				}))
+			})
+		})
+	}
+}
+
+func TestCollection_Explain(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		collection := dokvs.NewCollection(reviews, dokvs.WithFieldIndex[Review, string]("author"))
+
+		update(t, store, collection.Init)
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			reviews, err := collection.View(tx)
+			require.NoError(t, err)
+
+			for q, expected := range map[string]string{
+				`author = "bob" AND rating >= 4 ORDER BY created DESC LIMIT 20`: `INDEX LOOKUP reviews (author = "bob") -> FILTER (author = "bob" AND rating >= 4) -> SORT created DESC -> LIMIT 20`,
+				`rating >= 4 AND author = "bob"`:                                `INDEX LOOKUP reviews (author = "bob") -> FILTER (rating >= 4 AND author = "bob")`,
+				`author = "bob" OR author = "eve"`:                              `SCAN reviews -> FILTER (author = "bob" OR author = "eve")`,
+				`author != "bob"`:                                               `SCAN reviews -> FILTER author != "bob"`,
+				`author = null`:                                                 `SCAN reviews -> FILTER author = null`,
+				`LIMIT 5`:                                                       `SCAN reviews -> LIMIT 5`,
+			} {
+				plan, err := reviews.Explain(q)
+				require.NoError(t, err)
+				assert.Equal(t, expected, plan.String(), q)
+			}
+
+			return nil
+		}))
+	})
+}
+
+func reviewIDs(reviews []Review) (ids []string) {
+	for _, r := range reviews {
+		ids = append(ids, r.ID)
+	}
+
+	return
+}
+
+// BookV3 is the third version of Book in which author is renamed
+// to writer and a title is introduced.
+type BookV3 struct {
+	ID     string `json:"id"`
+	Writer string `json:"writer"`
+	Title  string `json:"title"`
+}
+
+var booksV3 = dokvs.NewSchema("books", func(b BookV3) []byte {
+	return []byte(b.ID)
+},
+	dokvs.WithUpgrade[BookV3](func(v []byte) ([]byte, error) {
+		var doc map[string]any
+		if err := json.Unmarshal(v, &doc); err != nil {
+			return nil, err
+		}
+
+		doc["writer"] = doc["author"]
+		delete(doc, "author")
+
+		return json.Marshal(doc)
+	}),
+	dokvs.WithUpgrade[BookV3](func(v []byte) ([]byte, error) {
+		var doc map[string]any
+		if err := json.Unmarshal(v, &doc); err != nil {
+			return nil, err
+		}
+
+		doc["title"] = "untitled"
+
+		return json.Marshal(doc)
+	}),
+)
+
+func TestCollection_Migrate(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx = context.Background()
+			v1  = dokvs.NewCollection[Book, string](books)
+			v3  = dokvs.NewCollection[BookV3, string](booksV3)
+		)
+
+		update(t, store, v1.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			books, err := v1.Update(tx)
+			require.NoError(t, err)
+
+			for i, author := range []string{"george", "ada", "grace", "alan", "barbara"} {
+				require.NoError(t, books.Put(ctx, Book{ID: string(rune('a' + i)), Author: author}))
+			}
+
+			return nil
+		})
+
+		update(t, store, func(tx kv.Update) error {
+			books, err := v3.Update(tx)
+			require.NoError(t, err)
+
+			// writes using the newer schema are stored at version 3
+			return books.Put(ctx, BookV3{ID: "b", Writer: "ada", Title: "notes"})
+		})
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			books, err := v3.View(tx)
+			require.NoError(t, err)
+
+			// documents are upgraded lazily as they are read
+			book, _, err := books.Fetch(ctx, "a")
+			require.NoError(t, err)
+			assert.Equal(t, BookV3{ID: "a", Writer: "george", Title: "untitled"}, book)
+
+			page, err := books.List(ctx, dokvs.ListPredicate[BookV3]{Limit: 2})
+			require.NoError(t, err)
+			assert.Equal(t, []BookV3{
+				{ID: "a", Writer: "george", Title: "untitled"},
+				{ID: "b", Writer: "ada", Title: "notes"},
+			}, page.Documents)
+
+			// the older schema cannot read documents written by the newer one
+			old, err := v1.View(tx)
+			require.NoError(t, err)
+
+			_, _, err = old.Fetch(ctx, "b")
+			assert.ErrorIs(t, err, dokvs.ErrUnsupportedVersion)
+
+			return nil
+		}))
+
+		var checkpoints []dokvs.PageToken
+		n, err := v3.Migrate(ctx, store, dokvs.MigrateOptions{
+			BatchSize: 2,
+			Checkpoint: func(token dokvs.PageToken) error {
+				checkpoints = append(checkpoints, token)
+				return nil
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 4, n)
+		assert.Len(t, checkpoints, 3)
+
+		// resuming from a checkpoint finds nothing left to rewrite
+		n, err = v3.Migrate(ctx, store, dokvs.MigrateOptions{Token: checkpoints[0]})
+		require.NoError(t, err)
+		assert.Equal(t, 0, n)
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			ks, err := tx.Keyspace([]byte("books"))
+			require.NoError(t, err)
+
+			items, err := ks.Range(ctx)
+			require.NoError(t, err)
+			require.Len(t, items, 5)
+
+			// reading with failing upgrades demonstrates every document is stored at version 3
+			noop := dokvs.NewCollection[Book, string](dokvs.NewSchema("books", func(b Book) []byte {
+				return []byte(b.ID)
+			},
+				dokvs.WithUpgrade[Book](failUpgrade),
+				dokvs.WithUpgrade[Book](failUpgrade),
+			))
+
+			books, err := noop.View(tx)
+			require.NoError(t, err)
+
+			return books.Iterate(ctx, func(Book) error { return nil })
+		}))
+	})
+}
+
+func TestCollection_MigrateWrapped(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx  = context.Background()
+			keys = dokvs.StaticKeyProvider{
+				Current: "k1",
+				Keys:    map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)},
+			}
+			// payloads are encrypted and then wrapped in a compression header
+			v1 = dokvs.NewCollection(books, dokvs.WithSerializer[Book, string](
+				dokvs.NewCompressedSerializer[Book](
+					dokvs.NewEncryptedSerializer[Book](dokvs.JSONSerializer[Book]{}, &keys),
+					dokvs.CompressionGzip, dokvs.WithCompressionThreshold[Book](0)),
+			))
+			v3 = dokvs.NewCollection(booksV3, dokvs.WithSerializer[BookV3, string](
+				dokvs.NewCompressedSerializer[BookV3](
+					dokvs.NewEncryptedSerializer[BookV3](dokvs.JSONSerializer[BookV3]{}, &keys),
+					dokvs.CompressionGzip, dokvs.WithCompressionThreshold[BookV3](0)),
+			))
+		)
+
+		update(t, store, v1.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			books, err := v1.Update(tx)
+			require.NoError(t, err)
+
+			return books.Put(ctx, Book{ID: "a", Author: "george"})
+		})
+
+		// upgrades receive the payload once decompressed and decrypted
+		require.NoError(t, store.View(func(tx kv.View) error {
+			books, err := v3.View(tx)
+			require.NoError(t, err)
+
+			book, _, err := books.Fetch(ctx, "a")
+			require.NoError(t, err)
+			assert.Equal(t, BookV3{ID: "a", Writer: "george", Title: "untitled"}, book)
+
+			return nil
+		}))
+
+		n, err := v3.Migrate(ctx, store, dokvs.MigrateOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			books, err := v3.View(tx)
+			require.NoError(t, err)
+
+			book, _, err := books.Fetch(ctx, "a")
+			require.NoError(t, err)
+			assert.Equal(t, BookV3{ID: "a", Writer: "george", Title: "untitled"}, book)
+
+			return nil
+		}))
+	})
+}
+
+func failUpgrade([]byte) ([]byte, error) {
+	return nil, errors.New("unexpected upgrade")
+}
+
+// compactJSON is a JSONSerializer which identifies as a distinct encoding.
+type compactJSON struct {
+	dokvs.JSONSerializer[Book]
+}
+
+func (compactJSON) ID() string { return "compact-json" }
+
+// usersV2 upgrades users by normalising their email addresses to lower case.
+var usersV2 = dokvs.NewSchema(
+	"users",
+	func(u User) []byte { return []byte(u.ID) },
+	dokvs.WithUnique("email", func(u User) []byte { return []byte(u.Email) }),
+	dokvs.WithUpgrade[User](func(v []byte) ([]byte, error) {
+		var u User
+		if err := json.Unmarshal(v, &u); err != nil {
+			return nil, err
+		}
+
+		u.Email = strings.ToLower(u.Email)
+
+		return json.Marshal(u)
+	}),
+)
+
+func byEmail(u User) []byte {
+	return []byte(u.Email)
+}
+
+func TestCollection_MigrateDerived(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx = context.Background()
+			v1  = dokvs.NewCollection(users, dokvs.WithIndex[User, string]("by_email", byEmail))
+			v2  = dokvs.NewCollection(usersV2, dokvs.WithIndex[User, string]("by_email", byEmail))
+		)
+
+		update(t, store, v1.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			users, err := v1.Update(tx)
+			require.NoError(t, err)
+
+			return users.Put(ctx, User{ID: "1", Email: "Ada@Example.com"})
+		})
+
+		update(t, store, v2.Init)
+
+		n, err := v2.Migrate(ctx, store, dokvs.MigrateOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		update(t, store, func(tx kv.Update) error {
+			users, err := v2.Update(tx)
+			require.NoError(t, err)
+
+			found, err := users.Lookup(ctx, "by_email", []byte("ada@example.com"))
+			require.NoError(t, err)
+			assert.Equal(t, []User{{ID: "1", Email: "ada@example.com"}}, found)
+
+			found, err = users.Lookup(ctx, "by_email", []byte("Ada@Example.com"))
+			require.NoError(t, err)
+			assert.Empty(t, found)
+
+			// the upgraded value is claimed and the original released
+			var violation *dokvs.ErrUniqueViolation
+			require.ErrorAs(t, users.Put(ctx, User{ID: "2", Email: "ada@example.com"}), &violation)
+			assert.Equal(t, []byte("1"), violation.Key)
+
+			return users.Put(ctx, User{ID: "3", Email: "Ada@Example.com"})
+		})
+	})
+}
+
+func TestCollection_WriteUpgraded(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx = context.Background()
+			v1  = dokvs.NewCollection(users, dokvs.WithIndex[User, string]("by_email", byEmail))
+			v2  = dokvs.NewCollection(usersV2, dokvs.WithIndex[User, string]("by_email", byEmail))
+		)
+
+		update(t, store, v1.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			users, err := v1.Update(tx)
+			require.NoError(t, err)
+
+			return users.PutMany(ctx,
+				User{ID: "1", Email: "Ada@Example.com"},
+				User{ID: "2", Email: "Grace@Example.com"},
+				User{ID: "3", Email: "Alan@Example.com"},
+			)
+		})
+
+		update(t, store, v2.Init)
+
+		// writes over documents which are upgraded as they are read remove
+		// the index entries and unique claims derived from them as written
+		update(t, store, func(tx kv.Update) error {
+			users, err := v2.Update(tx)
+			require.NoError(t, err)
+
+			require.NoError(t, users.Put(ctx, User{ID: "1", Email: "ada@example.com"}))
+			require.NoError(t, users.PutMany(ctx, User{ID: "2", Email: "grace@example.com"}))
+			require.NoError(t, users.Delete(ctx, User{ID: "3"}))
+
+			return nil
+		})
+
+		update(t, store, func(tx kv.Update) error {
+			users, err := v2.Update(tx)
+			require.NoError(t, err)
+
+			for _, email := range []string{"Ada@Example.com", "Grace@Example.com", "Alan@Example.com"} {
+				found, err := users.Lookup(ctx, "by_email", []byte(email))
+				require.NoError(t, err)
+				assert.Empty(t, found, email)
+			}
+
+			found, err := users.Lookup(ctx, "by_email", []byte("ada@example.com"))
+			require.NoError(t, err)
+			assert.Equal(t, []User{{ID: "1", Email: "ada@example.com"}}, found)
+
+			// the values as written were released
+			return users.PutMany(ctx,
+				User{ID: "4", Email: "Ada@Example.com"},
+				User{ID: "5", Email: "Grace@Example.com"},
+				User{ID: "6", Email: "Alan@Example.com"},
+			)
+		})
+	})
+}
+
+// markedSerializer writes books as a leading 0xDC byte followed by
+// their ID and author, such that values begin with the envelope marker.
+type markedSerializer struct{}
+
+func (markedSerializer) Serialize(b Book) ([]byte, error) {
+	return []byte("\xdc" + b.ID + "|" + b.Author), nil
+}
+
+func (markedSerializer) Deserialize(v []byte, b *Book) error {
+	parts := strings.SplitN(string(v[1:]), "|", 2)
+	if v[0] != 0xDC || len(parts) != 2 {
+		return errors.New("malformed book")
+	}
+
+	b.ID, b.Author = parts[0], parts[1]
+	return nil
+}
+
+func TestCollection_LegacyEnvelope(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx        = context.Background()
+			collection = dokvs.NewCollection(books, dokvs.WithSerializer[Book, string](markedSerializer{}))
+			fetch      = func(id string) (book Book, err error) {
+				err = store.View(func(tx kv.View) error {
+					books, err := collection.View(tx)
+					require.NoError(t, err)
+
+					book, _, err = books.Fetch(ctx, id)
+					return err
+				})
+
+				return
+			}
+			enveloped = func() (enveloped bool) {
+				require.NoError(t, store.View(func(tx kv.View) error {
+					desc, err := dokvs.DescribeCollection(ctx, tx, "books")
+					enveloped = desc.Enveloped
+					return err
+				}))
+
+				return
+			}
+		)
+
+		// a value written before envelopes were introduced which begins with the marker
+		update(t, store, func(tx kv.Update) error {
+			if err := tx.CreateKeyspace([]byte("books")); err != nil {
+				return err
+			}
+
+			documents, err := tx.Keyspace([]byte("books"))
+			require.NoError(t, err)
+
+			return documents.Put(ctx, []byte("a"), []byte("\xdca|george"))
+		})
+
+		update(t, store, collection.Init)
+		assert.False(t, enveloped())
+
+		book, err := fetch("a")
+		require.NoError(t, err)
+		assert.Equal(t, Book{ID: "a", Author: "george"}, book)
+
+		n, err := collection.Migrate(ctx, store, dokvs.MigrateOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.True(t, enveloped())
+
+		book, err = fetch("a")
+		require.NoError(t, err)
+		assert.Equal(t, Book{ID: "a", Author: "george"}, book)
+
+		// once enveloped, values which are not well formed envelopes are rejected
+		update(t, store, func(tx kv.Update) error {
+			documents, err := tx.Keyspace([]byte("books"))
+			require.NoError(t, err)
+
+			return documents.Put(ctx, []byte("b"), []byte("\xdcb|ada"))
+		})
+
+		_, err = fetch("b")
+		assert.ErrorIs(t, err, dokvs.ErrInvalidEnvelope)
+	})
+}
+
+func TestCollection_Catalog(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx        = context.Background()
+			collection = dokvs.NewCollection(books, dokvs.WithIndex[Book, string]("by_author", byAuthor))
+		)
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			descs, err := dokvs.ListCollections(ctx, tx)
+			require.NoError(t, err)
+			assert.Empty(t, descs)
+
+			_, err = dokvs.DescribeCollection(ctx, tx, "books")
+			assert.ErrorIs(t, err, dokvs.ErrCollectionNotFound)
+
+			return nil
+		}))
+
+		update(t, store, collection.Init)
+		update(t, store, dokvs.NewCollection[User, string](users).Init)
+
+		// initializing an existing collection retains its keyspaces
+		update(t, store, func(tx kv.Update) error {
+			books, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			return books.Put(ctx, Book{ID: "a", Author: "george"})
+		})
+
+		update(t, store, collection.Init)
+
+		var created time.Time
+		require.NoError(t, store.View(func(tx kv.View) error {
+			descs, err := dokvs.ListCollections(ctx, tx)
+			require.NoError(t, err)
+			require.Len(t, descs, 2)
+
+			created = descs[0].Created
+			assert.False(t, created.IsZero())
+
+			assert.Equal(t, dokvs.CollectionDescription{
+				Name:       "books",
+				Serializer: "json",
+				Version:    1,
+				Indexes:    []dokvs.IndexDescription{{Name: "by_author"}},
+				// the collection was empty when first initialized
+				Enveloped: true,
+				Created:   created,
+			}, descs[0])
+
+			desc, err := dokvs.DescribeCollection(ctx, tx, "users")
+			require.NoError(t, err)
+			assert.Equal(t, "users", desc.Name)
+			assert.Equal(t, []string{"email"}, desc.Unique)
+
+			books, err := collection.View(tx)
+			require.NoError(t, err)
+
+			_, _, err = books.Fetch(ctx, "a")
+			return err
+		}))
+
+		for name, test := range map[string]struct {
+			collection dokvs.Collection[Book, string]
+			// readable is true when the collection may still be opened for reading
+			readable bool
+		}{
+			"serializer": {collection: dokvs.NewCollection(books,
+				dokvs.WithIndex[Book, string]("by_author", byAuthor),
+				dokvs.WithSerializer[Book, string](compactJSON{}))},
+			"missing index": {collection: dokvs.NewCollection[Book, string](books), readable: true},
+			"field index":   {collection: dokvs.NewCollection(books, dokvs.WithFieldIndex[Book, string]("by_author"))},
+		} {
+			err := store.Update(test.collection.Init)
+			assert.ErrorIs(t, err, dokvs.ErrCatalogMismatch, name)
+
+			// opening the collection without initializing it is also rejected
+			err = store.Update(func(tx kv.Update) error {
+				_, err := test.collection.Update(tx)
+				return err
+			})
+			assert.ErrorIs(t, err, dokvs.ErrCatalogMismatch, name)
+
+			err = store.View(func(tx kv.View) error {
+				_, err := test.collection.View(tx)
+				return err
+			})
+
+			if test.readable {
+				assert.NoError(t, err, name)
+			} else {
+				assert.ErrorIs(t, err, dokvs.ErrCatalogMismatch, name)
+			}
+		}
+
+		// newer schema versions update the catalog and older ones are rejected
+		v2 := dokvs.NewCollection(dokvs.NewSchema("books", func(b Book) []byte {
+			return []byte(b.ID)
+		}, dokvs.WithUpgrade[Book](func(v []byte) ([]byte, error) { return v, nil })),
+			dokvs.WithIndex[Book, string]("by_author", byAuthor))
+
+		update(t, store, v2.Init)
+
+		assert.ErrorIs(t, store.Update(collection.Init), dokvs.ErrUnsupportedVersion)
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			desc, err := dokvs.DescribeCollection(ctx, tx, "books")
+			require.NoError(t, err)
+			assert.Equal(t, uint64(2), desc.Version)
+			assert.True(t, created.Equal(desc.Created))
+
+			return nil
+		}))
+	})
+}
+
+func TestCollection_DropTruncateRename(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx        = context.Background()
+			collection = dokvs.NewCollection(books, dokvs.WithIndex[Book, string]("by_author", byAuthor))
+			put        = func(c dokvs.Collection[Book, string], bs ...Book) {
+				update(t, store, func(tx kv.Update) error {
+					books, err := c.Update(tx)
+					require.NoError(t, err)
+
+					return books.PutMany(ctx, bs...)
+				})
+			}
+			lookup = func(c dokvs.Collection[Book, string], author string) (found []Book) {
+				require.NoError(t, store.View(func(tx kv.View) (err error) {
+					books, err := c.View(tx)
+					require.NoError(t, err)
+
+					found, err = books.Lookup(ctx, "by_author", []byte(author))
+					return err
+				}))
+
+				return
+			}
+		)
+
+		update(t, store, collection.Init)
+		put(collection, Book{ID: "a", Author: "george"}, Book{ID: "b", Author: "ada"})
+
+		// truncation removes documents along with their index entries
+		update(t, store, func(tx kv.Update) error {
+			return collection.Truncate(ctx, tx)
+		})
+
+		assert.Empty(t, lookup(collection, "george"))
+
+		put(collection, Book{ID: "c", Author: "grace"})
+
+		// a rename onto an occupied keyspace fails before any keyspace is moved
+		update(t, store, func(tx kv.Update) error {
+			if err := tx.CreateKeyspace([]byte("novels:index:by_author")); err != nil {
+				return err
+			}
+
+			index, err := tx.Keyspace([]byte("novels:index:by_author"))
+			require.NoError(t, err)
+
+			return index.Put(ctx, []byte("stale"), []byte("x"))
+		})
+
+		assert.ErrorIs(t, store.Update(func(tx kv.Update) error {
+			_, err := collection.Rename(ctx, tx, "novels")
+			return err
+		}), kv.ErrKeyspaceExists)
+
+		assert.Equal(t, []Book{{ID: "c", Author: "grace"}}, lookup(collection, "grace"))
+
+		update(t, store, func(tx kv.Update) error {
+			return tx.DeleteKeyspace(ctx, []byte("novels:index:by_author"))
+		})
+
+		var renamed dokvs.Collection[Book, string]
+		update(t, store, func(tx kv.Update) (err error) {
+			renamed, err = collection.Rename(ctx, tx, "archived_books")
+			return err
+		})
+
+		assert.Equal(t, []Book{{ID: "c", Author: "grace"}}, lookup(renamed, "grace"))
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			descs, err := dokvs.ListCollections(ctx, tx)
+			require.NoError(t, err)
+			require.Len(t, descs, 1)
+			assert.Equal(t, "archived_books", descs[0].Name)
+
+			return nil
+		}))
+
+		update(t, store, func(tx kv.Update) error {
+			return renamed.Drop(ctx, tx)
+		})
+
+		// dropping is idempotent and the collection can be initialized again
+		update(t, store, func(tx kv.Update) error {
+			return renamed.Drop(ctx, tx)
+		})
+
+		update(t, store, renamed.Init)
+		assert.Empty(t, lookup(renamed, "grace"))
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			descs, err := dokvs.ListCollections(ctx, tx)
+			require.NoError(t, err)
+			require.Len(t, descs, 1)
+			assert.Equal(t, "archived_books", descs[0].Name)
+
+			return nil
+		}))
+	})
+}
+
+var structs = dokvs.NewSchema("structs", func(s *structpb.Struct) []byte {
+	return []byte(s.GetFields()["id"].GetStringValue())
+})
+
+func TestCollection_ProtoSerializer(t *testing.T) {
+	for name, serializer := range map[string]dokvs.Serializer[*structpb.Struct]{
+		"protobuf":  dokvs.ProtoSerializer[*structpb.Struct]{},
+		"protojson": dokvs.ProtoJSONSerializer[*structpb.Struct]{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			forEachStore(t, func(t *testing.T, store kv.Store) {
+				var (
+					ctx        = context.Background()
+					collection = dokvs.NewCollection(structs, dokvs.WithSerializer[*structpb.Struct, string](serializer))
+				)
+
+				doc, err := structpb.NewStruct(map[string]any{"id": "a", "author": "george", "rating": 4})
+				require.NoError(t, err)
+
+				update(t, store, collection.Init)
+
+				update(t, store, func(tx kv.Update) error {
+					structs, err := collection.Update(tx)
+					require.NoError(t, err)
+
+					return structs.Put(ctx, doc)
+				})
+
+				require.NoError(t, store.View(func(tx kv.View) error {
+					structs, err := collection.View(tx)
+					require.NoError(t, err)
+
+					found, _, err := structs.Fetch(ctx, "a")
+					require.NoError(t, err)
+					assert.True(t, proto.Equal(doc, found), "expected %v, found %v", doc, found)
+
+					desc, err := dokvs.DescribeCollection(ctx, tx, "structs")
+					require.NoError(t, err)
+					assert.Equal(t, name, desc.Serializer)
+
+					return nil
+				}))
+			})
+		})
+	}
+}
+
+func TestCollection_EncryptedBinding(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx  = context.Background()
+			keys = dokvs.StaticKeyProvider{
+				Current: "k1",
+				Keys:    map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)},
+			}
+			encrypted = dokvs.NewEncryptedSerializer[Book](
+				dokvs.NewCompressedSerializer[Book](dokvs.JSONSerializer[Book]{}, dokvs.CompressionGzip), &keys)
+			collection = dokvs.NewCollection(books,
+				dokvs.WithSerializer[Book, string](encrypted),
+				dokvs.WithIndex[Book, string]("by_author", byAuthor))
+			fetch = func(c dokvs.Collection[Book, string], key string) (found dokvs.FetchResult[Book]) {
+				require.NoError(t, store.View(func(tx kv.View) error {
+					books, err := c.View(tx)
+					require.NoError(t, err)
+
+					found.Document, found.Revision, found.Err = books.Fetch(ctx, key)
+					return nil
+				}))
+
+				return
+			}
+			raw = func(keyspace, key string) (v []byte) {
+				require.NoError(t, store.View(func(tx kv.View) error {
+					ks, err := tx.Keyspace([]byte(keyspace))
+					require.NoError(t, err)
+
+					items, err := ks.Get(ctx, kv.Key([]byte(key)))
+					require.NoError(t, err)
+
+					v = append([]byte(nil), items[0].V...)
+					return nil
+				}))
+
+				return
+			}
+			write = func(keyspace, key string, v []byte) {
+				update(t, store, func(tx kv.Update) error {
+					ks, err := tx.Keyspace([]byte(keyspace))
+					require.NoError(t, err)
+
+					return ks.Put(ctx, []byte(key), v)
+				})
+			}
+		)
+
+		update(t, store, collection.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			books, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			return books.PutMany(ctx, Book{ID: "a", Author: "george"}, Book{ID: "b", Author: "ada"})
+		})
+
+		// a value swapped between documents fails authentication
+		a, b := raw("books", "a"), raw("books", "b")
+		write("books", "a", b)
+		assert.ErrorIs(t, fetch(collection, "a").Err, dokvs.ErrInvalidCiphertext)
+		write("books", "a", a)
+
+		// renamed documents are rebound to the new name of the collection
+		var renamed dokvs.Collection[Book, string]
+		update(t, store, func(tx kv.Update) (err error) {
+			renamed, err = collection.Rename(ctx, tx, "novels")
+			return err
+		})
+
+		assert.Equal(t, Book{ID: "a", Author: "george"}, fetch(renamed, "a").Document)
+		assert.Equal(t, Book{ID: "b", Author: "ada"}, fetch(renamed, "b").Document)
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			// the original documents are removed
+			if original, err := tx.Keyspace([]byte("books")); err == nil {
+				n, err := original.Count(ctx)
+				require.NoError(t, err)
+				assert.Zero(t, n)
+			} else {
+				assert.ErrorIs(t, err, kv.ErrKeyspaceNotFound)
+			}
+
+			books, err := renamed.View(tx)
+			require.NoError(t, err)
+
+			found, err := books.Lookup(ctx, "by_author", []byte("ada"))
+			require.NoError(t, err)
+			assert.Equal(t, []Book{{ID: "b", Author: "ada"}}, found)
+
+			return nil
+		}))
+
+		// a value copied from the original collection fails authentication
+		write("novels", "a", a)
+		assert.ErrorIs(t, fetch(renamed, "a").Err, dokvs.ErrInvalidCiphertext)
+
+		// values written before they were bound remain readable until reencrypted
+		unbound, err := encrypted.Serialize(Book{ID: "a", Author: "george"})
+		require.NoError(t, err)
+		write("novels", "a", append([]byte{0xDC, 0, 1}, unbound...))
+
+		assert.Equal(t, Book{ID: "a", Author: "george"}, fetch(renamed, "a").Document)
+
+		n, err := renamed.Reencrypt(ctx, store, dokvs.MigrateOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		n, err = renamed.Reencrypt(ctx, store, dokvs.MigrateOptions{})
+		require.NoError(t, err)
+		assert.Zero(t, n)
+
+		assert.Equal(t, Book{ID: "a", Author: "george"}, fetch(renamed, "a").Document)
+	})
+}
+
+func TestCollection_Reencrypt(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx  = context.Background()
+			keys = dokvs.StaticKeyProvider{
+				Current: "k1",
+				Keys: map[string][]byte{
+					"k1": bytes.Repeat([]byte{1}, 32),
+					"k2": bytes.Repeat([]byte{2}, 32),
+				},
+			}
+			collection = dokvs.NewCollection(books, dokvs.WithSerializer[Book, string](
+				dokvs.NewEncryptedSerializer[Book](dokvs.JSONSerializer[Book]{}, &keys),
+			))
+			all = func() (page dokvs.Page[Book], err error) {
+				err = store.View(func(tx kv.View) error {
+					books, err := collection.View(tx)
+					require.NoError(t, err)
+
+					page, err = books.List(ctx, dokvs.ListPredicate[Book]{})
+					return err
+				})
+
+				return
+			}
+		)
+
+		update(t, store, collection.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			books, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			return books.PutMany(ctx,
+				Book{ID: "a", Author: "george"},
+				Book{ID: "b", Author: "ada"},
+				Book{ID: "c", Author: "grace"},
+			)
+		})
+
+		_, err := collection.Reencrypt(ctx, store, dokvs.MigrateOptions{})
+		require.NoError(t, err)
+
+		keys.Current = "k2"
+
+		update(t, store, func(tx kv.Update) error {
+			books, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			return books.Put(ctx, Book{ID: "b", Author: "ada lovelace"})
+		})
+
+		n, err := collection.Reencrypt(ctx, store, dokvs.MigrateOptions{BatchSize: 2})
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		// once rewritten, documents no longer depend upon the retired key
+		delete(keys.Keys, "k1")
+
+		page, err := all()
+		require.NoError(t, err)
+		assert.Equal(t, []Book{
+			{ID: "a", Author: "george"},
+			{ID: "b", Author: "ada lovelace"},
+			{ID: "c", Author: "grace"},
+		}, page.Documents)
+
+		_, err = dokvs.NewCollection[Book, string](books).Reencrypt(ctx, store, dokvs.MigrateOptions{})
+		assert.ErrorIs(t, err, dokvs.ErrNotEncrypted)
+	})
+}
+
+func TestCollection_Registry(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx    = context.Background()
+			asJSON = dokvs.NewCollection[Book, string](books)
+			asCBOR = dokvs.NewCollection(books,
+				dokvs.WithSerializer[Book, string](dokvs.CBORSerializer[Book]{}),
+				dokvs.WithRegistry[Book, string](dokvs.NewRegistry[Book](dokvs.JSONSerializer[Book]{})))
+			// onlyCBOR cannot read values written as JSON
+			onlyCBOR = dokvs.NewCollection(books,
+				dokvs.WithSerializer[Book, string](dokvs.CBORSerializer[Book]{}),
+				dokvs.WithRegistry[Book, string](dokvs.NewRegistry[Book]()))
+			list = func(c dokvs.Collection[Book, string]) (page dokvs.Page[Book], err error) {
+				err = store.View(func(tx kv.View) error {
+					books, err := c.View(tx)
+					require.NoError(t, err)
+
+					page, err = books.List(ctx, dokvs.ListPredicate[Book]{})
+					return err
+				})
+
+				return
+			}
+			expected = []Book{
+				{ID: "a", Author: "george"},
+				{ID: "b", Author: "ada"},
+				{ID: "c", Author: "grace"},
+			}
+		)
+
+		update(t, store, asJSON.Init)
+
+		update(t, store, func(tx kv.Update) error {
+			books, err := asJSON.Update(tx)
+			require.NoError(t, err)
+
+			return books.PutMany(ctx, expected[0], expected[1])
+		})
+
+		// switching serializer is permitted when the previous one is registered
+		update(t, store, asCBOR.Init)
+		assert.ErrorIs(t, store.Update(asJSON.Init), dokvs.ErrCatalogMismatch)
+
+		update(t, store, func(tx kv.Update) error {
+			books, err := asCBOR.Update(tx)
+			require.NoError(t, err)
+
+			return books.Put(ctx, expected[2])
+		})
+
+		page, err := list(asCBOR)
+		require.NoError(t, err)
+		assert.Equal(t, expected, page.Documents)
+
+		_, err = list(onlyCBOR)
+		assert.Error(t, err)
+
+		// a collection which cannot read the recorded serializer is rejected when opened
+		asRegisteredJSON := dokvs.NewCollection(books, dokvs.WithRegistry[Book, string](dokvs.NewRegistry[Book]()))
+		assert.ErrorIs(t, store.View(func(tx kv.View) error {
+			_, err := asRegisteredJSON.View(tx)
+			return err
+		}), dokvs.ErrCatalogMismatch)
+
+		n, err := asCBOR.Migrate(ctx, store, dokvs.MigrateOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		page, err = list(onlyCBOR)
+		require.NoError(t, err)
+		assert.Equal(t, expected, page.Documents)
+
+		// corruption of a stored value is detected by its checksum
+		update(t, store, func(tx kv.Update) error {
+			ks, err := tx.Keyspace([]byte("books"))
+			require.NoError(t, err)
+
+			items, err := ks.Get(ctx, kv.Key([]byte("b")))
+			require.NoError(t, err)
+
+			v := append([]byte(nil), items[0].V...)
+			v[len(v)-1] ^= 0xFF
+
+			return ks.Put(ctx, []byte("b"), v)
+		})
+
+		_, err = list(asCBOR)
+		assert.ErrorIs(t, err, dokvs.ErrChecksumMismatch)
+
+		// values tagged with a format outside of the registry cannot be read
+		update(t, store, func(tx kv.Update) error {
+			ks, err := tx.Keyspace([]byte("books"))
+			require.NoError(t, err)
+
+			// magic, format flag, version 1, then the format "xml" and its payload
+			return ks.Put(ctx, []byte("b"), []byte("\xdc\x01\x01\x03xml<book/>"))
+		})
+
+		_, err = list(asCBOR)
+		assert.ErrorIs(t, err, dokvs.ErrUnknownFormat)
+	})
+}
+
+type Account struct {
+	ID      string `json:"id"`
+	Owner   Owner  `json:"owner"`
+	Balance int    `json:"balance"`
+}
+
+type Owner struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func (a *Account) Validate() error {
+	verr := &dokvs.ValidationError{}
+	if a.Owner.Name == "" {
+		verr.Add("owner.name", "is required")
+	}
+
+	if !strings.Contains(a.Owner.Email, "@") {
+		verr.Add("owner.email", "is not an email address")
+	}
+
+	return verr.Err()
+}
+
+var accounts = dokvs.NewSchema("accounts", func(a Account) []byte {
+	return []byte(a.ID)
+})
+
+func TestCollection_Validation(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx        = context.Background()
+			collection = dokvs.NewCollection(accounts, dokvs.WithValidator[Account, string](func(a Account) error {
+				if a.Balance < 0 {
+					return errors.New("balance must not be negative")
+				}
+
+				return nil
+			}))
+			valid = Account{ID: "a", Owner: Owner{Name: "ada", Email: "ada@example.com"}, Balance: 10}
+		)
+
+		update(t, store, collection.Init)
+
+		require.NoError(t, store.Update(func(tx kv.Update) error {
+			accounts, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			require.NoError(t, accounts.Put(ctx, valid))
+
+			var verr *dokvs.ValidationError
+			err = accounts.Put(ctx, Account{ID: "b", Owner: Owner{Email: "grace"}, Balance: -1})
+			require.ErrorAs(t, err, &verr)
+			assert.Equal(t, []byte("b"), verr.Key)
+			assert.Equal(t, []dokvs.Violation{
+				{Field: "owner.name", Message: "is required"},
+				{Field: "owner.email", Message: "is not an email address"},
+				{Message: "balance must not be negative"},
+			}, verr.Violations)
+			assert.EqualError(t, err, `document "b" is invalid: owner.name: is required; `+
+				`owner.email: is not an email address; balance must not be negative`)
+
+			assert.ErrorAs(t, accounts.Insert(ctx, Account{ID: "c"}), &verr)
+
+			assert.ErrorAs(t, accounts.Modify(ctx, "a", func(a *Account) error {
+				a.Balance -= 20
+				return nil
+			}), &verr)
+
+			assert.ErrorAs(t, accounts.PutMany(ctx, Account{ID: "d", Owner: valid.Owner}, Account{ID: "e"}), &verr)
+			assert.Equal(t, []byte("e"), verr.Key)
+
+			found, _, err := accounts.Fetch(ctx, "a")
+			require.NoError(t, err)
+			assert.Equal(t, valid, found)
+
+			page, err := accounts.List(ctx, dokvs.ListPredicate[Account]{})
+			require.NoError(t, err)
+			assert.Equal(t, []Account{valid}, page.Documents)
+
+			return nil
+		}))
+	})
+}
+
+func TestCollection_ValidationNoViolations(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx        = context.Background()
+			collection = dokvs.NewCollection(accounts,
+				dokvs.WithValidator[Account, string](func(Account) error {
+					var verr *dokvs.ValidationError
+					return verr
+				}),
+				dokvs.WithValidator[Account, string](func(Account) error {
+					return &dokvs.ValidationError{}
+				}))
+			valid = Account{ID: "a", Owner: Owner{Name: "ada", Email: "ada@example.com"}, Balance: 10}
+		)
+
+		update(t, store, collection.Init)
+
+		// validators returning a typed nil or an empty ValidationError report no violations
+		update(t, store, func(tx kv.Update) error {
+			accounts, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			require.NoError(t, accounts.Put(ctx, valid))
+
+			found, _, err := accounts.Fetch(ctx, "a")
+			require.NoError(t, err)
+			assert.Equal(t, valid, found)
+
+			return nil
+		})
+	})
+}
+
+func TestCollection_Hooks(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store kv.Store) {
+		var (
+			ctx      = context.Background()
+			errLimit = errors.New("balance over limit")
+			errOpen  = errors.New("account has a balance")
+			puts     [][2]*Account
+			deleted  []string
+		)
+
+		collection := dokvs.NewCollection(accounts,
+			dokvs.WithBeforePut[Account, string](func(_ context.Context, _ kv.Update, old, new *Account) error {
+				if new.Balance > 1000 {
+					return errLimit
+				}
+
+				new.Owner.Email = strings.ToLower(new.Owner.Email)
+				return nil
+			}),
+			dokvs.WithAfterPut[Account, string](func(ctx context.Context, tx kv.Update, old, new *Account) error {
+				puts = append(puts, [2]*Account{old, new})
+
+				audit, err := tx.Keyspace([]byte("audit"))
+				if err != nil {
+					return err
+				}
+
+				return audit.Put(ctx, []byte(new.ID), []byte(strconv.Itoa(new.Balance)))
+			}),
+			dokvs.WithBeforeDelete[Account, string](func(_ context.Context, _ kv.Update, old, new *Account) error {
+				if old.Balance != 0 {
+					return errOpen
+				}
+
+				return nil
+			}),
+			dokvs.WithAfterDelete[Account, string](func(_ context.Context, _ kv.Update, old, new *Account) error {
+				deleted = append(deleted, old.ID)
+				return nil
+			}),
+		)
+
+		update(t, store, func(tx kv.Update) error {
+			if err := tx.CreateKeyspace([]byte("audit")); err != nil && !errors.Is(err, kv.ErrKeyspaceExists) {
+				return err
+			}
+
+			return collection.Init(tx)
+		})
+
+		owner := Owner{Name: "ada", Email: "Ada@Example.com"}
+
+		require.NoError(t, store.Update(func(tx kv.Update) error {
+			accounts, err := collection.Update(tx)
+			require.NoError(t, err)
+
+			require.NoError(t, accounts.Put(ctx, Account{ID: "a", Owner: owner, Balance: 10}))
+			require.NoError(t, accounts.Modify(ctx, "a", func(a *Account) error {
+				a.Balance = 0
+				return nil
+			}))
+			require.NoError(t, accounts.PutMany(ctx,
+				Account{ID: "b", Owner: owner},
+				Account{ID: "c", Owner: owner, Balance: 5},
+				Account{ID: "d", Owner: owner},
+			))
+
+			assert.ErrorIs(t, accounts.Put(ctx, Account{ID: "e", Owner: owner, Balance: 1001}), errLimit)
+			assert.ErrorIs(t, accounts.PutMany(ctx, Account{ID: "e", Owner: owner}, Account{ID: "f", Owner: owner, Balance: 1001}), errLimit)
+
+			found, _, err := accounts.Fetch(ctx, "a")
+			require.NoError(t, err)
+			assert.Equal(t, "ada@example.com", found.Owner.Email)
+
+			_, _, err = accounts.Fetch(ctx, "e")
+			assert.ErrorIs(t, err, dokvs.ErrNotFound)
+
+			require.Len(t, puts, 5)
+			assert.Nil(t, puts[0][0])
+			assert.Equal(t, Account{ID: "a", Owner: Owner{Name: "ada", Email: "ada@example.com"}, Balance: 10}, *puts[0][1])
+			assert.Equal(t, 10, puts[1][0].Balance)
+			assert.Equal(t, 0, puts[1][1].Balance)
+
+			assert.ErrorIs(t, accounts.Delete(ctx, Account{ID: "c"}), errOpen)
+			assert.ErrorIs(t, accounts.DeleteMany(ctx, Account{ID: "b"}, Account{ID: "c"}), errOpen)
+
+			require.NoError(t, accounts.Delete(ctx, Account{ID: "a"}))
+			require.NoError(t, accounts.Delete(ctx, Account{ID: "missing"}))
+			require.NoError(t, accounts.DeleteMany(ctx, Account{ID: "b"}))
+
+			n, err := accounts.DeleteWhere(ctx, func(a Account) bool { return a.Balance == 0 })
+			require.NoError(t, err)
+			assert.Equal(t, 1, n)
+
+			_, err = accounts.DeleteRange(ctx)
+			assert.ErrorIs(t, err, errOpen)
+
+			assert.Equal(t, []string{"a", "b", "d"}, deleted)
+
+			page, err := accounts.List(ctx, dokvs.ListPredicate[Account]{})
+			require.NoError(t, err)
+			require.Len(t, page.Documents, 1)
+			assert.Equal(t, "c", page.Documents[0].ID)
+
+			return nil
+		}))
+
+		require.NoError(t, store.View(func(tx kv.View) error {
+			audit, err := tx.Keyspace([]byte("audit"))
+			require.NoError(t, err)
+
+			for key, balance := range map[string]string{"a": "0", "b": "0", "c": "5", "d": "0"} {
+				v, err := audit.Get(ctx, kv.Key([]byte(key)))
+				require.NoError(t, err)
+				assert.Equal(t, balance, string(v[0].V))
+			}
+
+			return nil
+		}))
+	})
+}
+
+func update(t *testing.T, store kv.Store, fn func(kv.Update) error) {
+	t.Helper()
+
+	require.NoError(t, store.Update(fn))
+}
+
+// forEachStore runs the provided test against an empty store
+// for each of the supported backends.
+func forEachStore(t *testing.T, fn func(t *testing.T, store kv.Store)) {
+	t.Run("boltdb", func(t *testing.T) {
+		db, err := bolt.Open(filepath.Join(t.TempDir(), "testing.bolt"), 0666, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+
+		fn(t, boltdb.New(db))
+	})
+
+	t.Run("etcd", func(t *testing.T) {
+		integration.BeforeTest(t)
+
+		cluster := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+		t.Cleanup(func() { cluster.Terminate(t) })
+
+		client, err := cluster.ClusterClient()
+		require.NoError(t, err)
+
+		fn(t, etcd.New(client.KV))
+	})
+}
@@ -0,0 +1,267 @@
+package dokvs
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/georgemac/dokvs/pkg/kv"
+)
+
+// ErrUnknownKey is returned when a KeyProvider has no key with the requested ID.
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// ErrInvalidCiphertext is returned when an encrypted value is malformed or fails authentication.
+var ErrInvalidCiphertext = errors.New("invalid ciphertext")
+
+// ErrNotEncrypted is returned by Reencrypt when the collection is not
+// configured with an EncryptedSerializer.
+var ErrNotEncrypted = errors.New("collection is not encrypted")
+
+// KeyProvider supplies the keys used by an EncryptedSerializer.
+// Keys are rotated by changing the current key while continuing to provide
+// previous keys until every value encrypted with them has been rewritten.
+type KeyProvider interface {
+	// CurrentKey returns the ID and key with which values are encrypted.
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the provided ID, or ErrUnknownKey.
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeyProvider is a KeyProvider over a fixed set of keys.
+type StaticKeyProvider struct {
+	// Current is the ID of the key with which values are encrypted.
+	Current string
+	// Keys are AES keys of 16, 24 or 32 bytes by ID.
+	Keys map[string][]byte
+}
+
+func (p StaticKeyProvider) CurrentKey() (string, []byte, error) {
+	key, err := p.Key(p.Current)
+	return p.Current, key, err
+}
+
+func (p StaticKeyProvider) Key(id string) ([]byte, error) {
+	key, ok := p.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("key %q: %w", id, ErrUnknownKey)
+	}
+
+	return key, nil
+}
+
+// EncryptedSerializer wraps a Serializer encrypting the values it produces
+// using AES-GCM under the current key of a KeyProvider. Each value is
+// encoded as:
+//
+//	key ID length (1 byte) | key ID | nonce (12 bytes) | ciphertext
+//
+// The key ID is authenticated along with the ciphertext, and is used to
+// look up the key with which to decrypt, so values encrypted under previous
+// keys remain readable while their keys are provided.
+//
+// Values written by a collection also authenticate the name of the collection
+// and the primary key of their document, such that a value copied or swapped
+// between documents, or between collections sharing keys, fails to decrypt
+// with ErrInvalidCiphertext. Collection.Rename rebinds the values of the
+// collection to its new name. Values written before they were bound to their
+// document authenticate their key ID alone and remain readable, but can be
+// swapped undetected. Rotating to a new key and running Collection.Reencrypt
+// rewrites them bound to their document, after which the previous key is
+// retired.
+type EncryptedSerializer[T any] struct {
+	serializer Serializer[T]
+	keys       KeyProvider
+}
+
+// NewEncryptedSerializer returns an EncryptedSerializer which encrypts the
+// output of serializer using keys supplied by the provider.
+func NewEncryptedSerializer[T any](serializer Serializer[T], keys KeyProvider) EncryptedSerializer[T] {
+	return EncryptedSerializer[T]{serializer: serializer, keys: keys}
+}
+
+// ID identifies the wrapped serializer.
+func (s EncryptedSerializer[T]) ID() string {
+	return "encrypted:" + serializerID(s.serializer)
+}
+
+func (s EncryptedSerializer[T]) Serialize(t T) ([]byte, error) {
+	return s.serialize(t, nil)
+}
+
+func (s EncryptedSerializer[T]) serialize(t T, ad []byte) ([]byte, error) {
+	plaintext, err := serialize(s.serializer, t, ad)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.seal(plaintext, ad)
+}
+
+// seal encrypts plaintext under the current key authenticating its key ID
+// followed by ad.
+func (s EncryptedSerializer[T]) seal(plaintext, ad []byte) ([]byte, error) {
+	id, key, err := s.keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(id) > 255 {
+		return nil, fmt.Errorf("key ID %q exceeds 255 bytes", id)
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := append([]byte{byte(len(id))}, id...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	v := append(header, nonce...)
+
+	return aead.Seal(v, nonce, plaintext, append(header[:len(header):len(header)], ad...)), nil
+}
+
+func (s EncryptedSerializer[T]) Deserialize(v []byte, t *T) error {
+	plaintext, _, err := s.decrypt(v, nil)
+	if err != nil {
+		return err
+	}
+
+	return s.serializer.Deserialize(plaintext, t)
+}
+
+func (s EncryptedSerializer[T]) unwrap(v, ad []byte) ([]byte, Serializer[T], error) {
+	plaintext, _, err := s.decrypt(v, ad)
+	return plaintext, s.serializer, err
+}
+
+func (s EncryptedSerializer[T]) rebind(v, from, to []byte) ([]byte, error) {
+	plaintext, _, err := s.decrypt(v, from)
+	if err != nil {
+		return nil, err
+	}
+
+	if plaintext, err = rebind(s.serializer, plaintext, from, to); err != nil {
+		return nil, err
+	}
+
+	return s.seal(plaintext, to)
+}
+
+func (s EncryptedSerializer[T]) binds() bool { return true }
+
+// decrypt opens v authenticating its key ID followed by ad. Values sealed
+// before they were bound to associated data are opened authenticating their
+// key ID alone, and are reported as not bound.
+func (s EncryptedSerializer[T]) decrypt(v, ad []byte) (plaintext []byte, bound bool, err error) {
+	id, header, ok := keyID(v)
+	if !ok {
+		return nil, false, ErrInvalidCiphertext
+	}
+
+	key, err := s.keys.Key(id)
+	if err != nil {
+		return nil, false, err
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	rest := v[len(header):]
+	if len(rest) < aead.NonceSize() {
+		return nil, false, ErrInvalidCiphertext
+	}
+
+	nonce, ciphertext := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+
+	if plaintext, err = aead.Open(nil, nonce, ciphertext, append(header[:len(header):len(header)], ad...)); err == nil {
+		return plaintext, true, nil
+	}
+
+	if len(ad) > 0 {
+		if plaintext, err = aead.Open(nil, nonce, ciphertext, header); err == nil {
+			return plaintext, false, nil
+		}
+	}
+
+	return nil, false, fmt.Errorf("key %q: %w", id, ErrInvalidCiphertext)
+}
+
+// stale reports whether v is encrypted under a key other than the current key,
+// or is not bound to ad.
+func (s EncryptedSerializer[T]) stale(v, ad []byte) (bool, error) {
+	current, _, err := s.keys.CurrentKey()
+	if err != nil {
+		return false, err
+	}
+
+	id, _, ok := keyID(v)
+	if !ok {
+		return false, ErrInvalidCiphertext
+	}
+
+	if id != current {
+		return true, nil
+	}
+
+	_, bound, err := s.decrypt(v, ad)
+
+	return !bound, err
+}
+
+// keyID returns the key ID of an encrypted value along with the header containing it.
+func keyID(v []byte) (string, []byte, bool) {
+	if len(v) < 1 || len(v) < 1+int(v[0]) {
+		return "", nil, false
+	}
+
+	header := v[:1+int(v[0])]
+
+	return string(header[1:]), header, true
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// rotatable is implemented by serializers whose values can be rewritten
+// under a newer key.
+type rotatable interface {
+	stale(v, ad []byte) (bool, error)
+}
+
+// Reencrypt rewrites every document in the collection which is encrypted
+// under a key other than the current key of its EncryptedSerializer, or which
+// is not bound to its document, and returns the number of documents
+// rewritten. It proceeds in checkpointed batches exactly as Migrate, after
+// which previous keys can be retired.
+func (c Collection[D, K]) Reencrypt(ctx context.Context, store kv.Store, opts MigrateOptions) (int, error) {
+	serializer, ok := c.serializer.(rotatable)
+	if !ok {
+		return 0, ErrNotEncrypted
+	}
+
+	return c.rewrite(ctx, store, opts, func(key []byte, env envelope) (bool, error) {
+		if c.staleFormat(env) {
+			return true, nil
+		}
+
+		return serializer.stale(env.payload, c.associatedData(key))
+	})
+}
@@ -0,0 +1,306 @@
+package dokvs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/georgemac/dokvs/pkg/keyenc"
+)
+
+// ErrInvalidEnvelope is returned when a stored value has a malformed envelope.
+var ErrInvalidEnvelope = errors.New("invalid document envelope")
+
+// ErrUnsupportedVersion is returned when a stored document was written using
+// a newer version of a schema than the one configured on the collection.
+var ErrUnsupportedVersion = errors.New("unsupported document version")
+
+// ErrChecksumMismatch is returned when the checksum recorded in the envelope
+// of a stored value does not match its payload.
+var ErrChecksumMismatch = errors.New("document checksum mismatch")
+
+// ErrUnknownFormat is returned when a stored value was written using a
+// serializer which is not in the registry of the collection.
+var ErrUnknownFormat = errors.New("unknown document format")
+
+// envelopeMagic marks the beginning of an enveloped value.
+// Values written before envelopes were introduced are read as version 1 of
+// their schema, unless the catalog records that every value of the collection
+// is enveloped (see CollectionDescription.Enveloped).
+const envelopeMagic byte = 0xDC
+
+const (
+	// flagFormat denotes that the envelope records the ID of the serializer of the payload.
+	flagFormat byte = 1 << iota
+	// flagChecksum denotes that the envelope records a CRC-32C checksum of the payload.
+	flagChecksum
+
+	knownFlags = flagFormat | flagChecksum
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// envelope wraps the output of a Serializer with the version of the schema
+// it was written with and, optionally, the ID of the serializer and a checksum.
+// It is encoded as:
+//
+//	magic (1 byte) | flags (1 byte) | version (uvarint)
+//	  | [format length (uvarint) | format] | [checksum (4 bytes)] | payload
+//
+// The format and checksum are only present when their flags are set.
+type envelope struct {
+	version  uint64
+	format   string
+	checksum bool
+	payload  []byte
+	// legacy is set for values written before envelopes were introduced.
+	legacy bool
+}
+
+func (e envelope) encode() []byte {
+	v := make([]byte, 2+2*binary.MaxVarintLen64+len(e.format)+crc32.Size+len(e.payload))
+	v[0] = envelopeMagic
+
+	n := 2 + binary.PutUvarint(v[2:], e.version)
+
+	if e.format != "" {
+		v[1] |= flagFormat
+		n += binary.PutUvarint(v[n:], uint64(len(e.format)))
+		n += copy(v[n:], e.format)
+	}
+
+	if e.checksum {
+		v[1] |= flagChecksum
+		binary.BigEndian.PutUint32(v[n:], crc32.Checksum(e.payload, castagnoli))
+		n += crc32.Size
+	}
+
+	n += copy(v[n:], e.payload)
+
+	return v[:n]
+}
+
+// decodeEnvelope decodes the envelope of a stored value. Unless every value of
+// the collection is known to be enveloped, a value which is not a well formed
+// envelope is read as one written before envelopes were introduced, including
+// those which happen to begin with envelopeMagic.
+func decodeEnvelope(v []byte, enveloped bool) (envelope, error) {
+	env, err := parseEnvelope(v)
+	if enveloped || !errors.Is(err, ErrInvalidEnvelope) {
+		return env, err
+	}
+
+	return envelope{version: 1, payload: v, legacy: true}, nil
+}
+
+func parseEnvelope(v []byte) (env envelope, err error) {
+	if len(v) < 2 || v[0] != envelopeMagic || v[1]&^knownFlags != 0 {
+		return env, ErrInvalidEnvelope
+	}
+
+	flags, rest := v[1], v[2:]
+
+	var n int
+	if env.version, n = binary.Uvarint(rest); n <= 0 || env.version == 0 {
+		return env, ErrInvalidEnvelope
+	}
+
+	rest = rest[n:]
+
+	if flags&flagFormat != 0 {
+		length, n := binary.Uvarint(rest)
+		if n <= 0 || uint64(len(rest)-n) < length {
+			return env, ErrInvalidEnvelope
+		}
+
+		env.format, rest = string(rest[n:n+int(length)]), rest[n+int(length):]
+	}
+
+	if flags&flagChecksum != 0 {
+		if len(rest) < crc32.Size {
+			return env, ErrInvalidEnvelope
+		}
+
+		env.checksum = true
+		if binary.BigEndian.Uint32(rest) != crc32.Checksum(rest[crc32.Size:], castagnoli) {
+			return env, ErrChecksumMismatch
+		}
+
+		rest = rest[crc32.Size:]
+	}
+
+	env.payload = rest
+
+	return env, nil
+}
+
+// encode serializes d stored at key and wraps it in an envelope at the current
+// schema version. When a registry is configured the envelope also records the
+// ID of the serializer and a checksum.
+func (c Collection[D, K]) encode(key []byte, d D) ([]byte, error) {
+	payload, err := serialize(c.serializer, d, c.associatedData(key))
+	if err != nil {
+		return nil, err
+	}
+
+	env := envelope{version: c.schema.Version(), payload: payload}
+	if c.registry != nil {
+		env.format, env.checksum = serializerID(c.serializer), true
+	}
+
+	return env.encode(), nil
+}
+
+// decode unwraps the value stored at key, upgrades it to the current schema
+// version and deserializes it into d projecting onto fields when supported.
+func (c Collection[D, K]) decode(key, v []byte, d *D, fields []string) error {
+	env, err := decodeEnvelope(v, c.enveloped)
+	if err != nil {
+		return err
+	}
+
+	payload, serializer, err := c.unwrap(key, env)
+	if err != nil {
+		return err
+	}
+
+	if payload, err = c.upgrade(env.version, payload); err != nil {
+		return err
+	}
+
+	if fd, ok := serializer.(FieldDeserializer[D]); ok && len(fields) > 0 {
+		return fd.DeserializeFields(payload, d, fields)
+	}
+
+	return serializer.Deserialize(payload, d)
+}
+
+// written returns the document in env as it was written, before any upgrades,
+// from which its index entries and unique claims were derived. upgraded is the
+// document read from env at the current version, which is returned when env is
+// current or cannot be read without upgrading, in which case its derived state
+// is assumed to be unchanged by its upgrades.
+func (c Collection[D, K]) written(key []byte, env envelope, upgraded *D) *D {
+	if env.version == c.schema.Version() {
+		return upgraded
+	}
+
+	payload, serializer, err := c.unwrap(key, env)
+	if err != nil {
+		return upgraded
+	}
+
+	var d D
+	if err := serializer.Deserialize(payload, &d); err != nil {
+		return upgraded
+	}
+
+	return &d
+}
+
+// unwrap returns the payload of env stored at key as output by the innermost
+// of the serializers with which it was written, along with that serializer.
+func (c Collection[D, K]) unwrap(key []byte, env envelope) ([]byte, Serializer[D], error) {
+	serializer, err := c.serializerFor(env)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	payload, ad := env.payload, c.associatedData(key)
+	for {
+		w, ok := serializer.(wrapper[D])
+		if !ok {
+			return payload, serializer, nil
+		}
+
+		if payload, serializer, err = w.unwrap(payload, ad); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// associatedData identifies the document stored at key to serializers which
+// bind their output to it (see EncryptedSerializer).
+func (c Collection[D, K]) associatedData(key []byte) []byte {
+	return keyenc.Encode(keyenc.Bytes(c.schema.Collection()), keyenc.Bytes(key))
+}
+
+// wrapper is implemented by serializers which transform the output of another
+// serializer, such that payloads are unwrapped before upgrades are applied.
+// Wrappers may bind their output to associated data identifying the document
+// it is stored as, which is then required to unwrap it.
+type wrapper[T any] interface {
+	// serialize serializes t bound to ad.
+	serialize(t T, ad []byte) ([]byte, error)
+	// unwrap returns the output of the wrapped serializer from v along with it.
+	unwrap(v, ad []byte) ([]byte, Serializer[T], error)
+	// rebind returns v bound to the associated data to rather than from.
+	rebind(v, from, to []byte) ([]byte, error)
+	// binds reports whether output is bound to associated data.
+	binds() bool
+}
+
+// serialize serializes t using serializer, binding its output to ad when supported.
+func serialize[T any](serializer Serializer[T], t T, ad []byte) ([]byte, error) {
+	if w, ok := serializer.(wrapper[T]); ok {
+		return w.serialize(t, ad)
+	}
+
+	return serializer.Serialize(t)
+}
+
+// rebind returns v, written by serializer, bound to to rather than from.
+func rebind[T any](serializer Serializer[T], v, from, to []byte) ([]byte, error) {
+	if w, ok := serializer.(wrapper[T]); ok {
+		return w.rebind(v, from, to)
+	}
+
+	return v, nil
+}
+
+// binds reports whether serializer binds its output to associated data.
+func binds[T any](serializer Serializer[T]) bool {
+	w, ok := serializer.(wrapper[T])
+	return ok && w.binds()
+}
+
+// serializerFor returns the serializer with which to read the payload of env.
+func (c Collection[D, K]) serializerFor(env envelope) (Serializer[D], error) {
+	if c.registry == nil || env.format == serializerID(c.serializer) {
+		return c.serializer, nil
+	}
+
+	if env.format == "" {
+		return c.registry.untagged(c.serializer), nil
+	}
+
+	serializer, ok := c.registry.Lookup(env.format)
+	if !ok {
+		return nil, fmt.Errorf("format %q: %w", env.format, ErrUnknownFormat)
+	}
+
+	return serializer, nil
+}
+
+// staleFormat reports whether env was not written by the configured serializer
+// of a collection with a registry.
+func (c Collection[D, K]) staleFormat(env envelope) bool {
+	return c.registry != nil && env.format != serializerID(c.serializer)
+}
+
+// upgrade applies the upgrades of the schema to a payload written at version.
+func (c Collection[D, K]) upgrade(version uint64, payload []byte) (_ []byte, err error) {
+	current, upgrades := c.schema.Version(), c.schema.Upgrades()
+	if version > current || current-1 > uint64(len(upgrades)) {
+		return nil, fmt.Errorf("version %d: %w", version, ErrUnsupportedVersion)
+	}
+
+	for ; version < current; version++ {
+		if payload, err = upgrades[version-1](payload); err != nil {
+			return nil, fmt.Errorf("upgrading from version %d: %w", version, err)
+		}
+	}
+
+	return payload, nil
+}
@@ -31,5 +31,5 @@ func ExampleCollection() {
 
 	var view kv.View
 	recipesView, _ := recipes.View(view)
-	_, _ = recipesView.Fetch(ctx, ID("my_recipe"))
+	_, _, _ = recipesView.Fetch(ctx, ID("my_recipe"))
 }
@@ -0,0 +1,86 @@
+package dokvs
+
+import (
+	"context"
+
+	"github.com/georgemac/dokvs/pkg/kv"
+)
+
+// Hook is called as a document is written by a CollectionUpdate. It runs
+// within the same kv.Update as the write, which it receives as tx so that
+// it can make related writes, e.g. to counters or audit keyspaces.
+//
+// old is the previously stored document, or nil when there was none, and
+// new is the document being written, or nil when it is being deleted.
+//
+// Before hooks run prior to the write and can abort it by returning an error,
+// which is returned to the caller. BeforePut hooks may modify *new to set
+// derived fields, although not its primary key. After hooks run once the
+// write has been applied and an error they return is returned to the caller;
+// on backends with transactional updates (e.g. bolt) returning it from the
+// update rolls back the write.
+//
+// Hooks run for every document written by Put, PutMany, Modify and the other
+// write operations, including each attempt of a retried Modify. They are
+// not run by Migrate or Reencrypt, which rewrite documents without changing them.
+type Hook[D any] func(ctx context.Context, tx kv.Update, old, new *D) error
+
+// hooks are the lifecycle hooks configured on a collection.
+type hooks[D any] struct {
+	beforePut, afterPut       []Hook[D]
+	beforeDelete, afterDelete []Hook[D]
+}
+
+// any returns true when any hook is configured.
+func (h hooks[D]) any() bool {
+	return len(h.beforePut)+len(h.afterPut) > 0 || h.onDelete()
+}
+
+// onDelete returns true when any delete hook is configured.
+func (h hooks[D]) onDelete() bool {
+	return len(h.beforeDelete)+len(h.afterDelete) > 0
+}
+
+// WithBeforePut configures a hook which runs before each document is put.
+func WithBeforePut[D any, K AnyBytes](fn Hook[D]) func(*Collection[D, K]) {
+	return func(c *Collection[D, K]) {
+		c.hooks.beforePut = append(c.hooks.beforePut, fn)
+	}
+}
+
+// WithAfterPut configures a hook which runs after each document is put.
+func WithAfterPut[D any, K AnyBytes](fn Hook[D]) func(*Collection[D, K]) {
+	return func(c *Collection[D, K]) {
+		c.hooks.afterPut = append(c.hooks.afterPut, fn)
+	}
+}
+
+// WithBeforeDelete configures a hook which runs before each stored document is deleted.
+func WithBeforeDelete[D any, K AnyBytes](fn Hook[D]) func(*Collection[D, K]) {
+	return func(c *Collection[D, K]) {
+		c.hooks.beforeDelete = append(c.hooks.beforeDelete, fn)
+	}
+}
+
+// WithAfterDelete configures a hook which runs after each stored document is deleted.
+func WithAfterDelete[D any, K AnyBytes](fn Hook[D]) func(*Collection[D, K]) {
+	return func(c *Collection[D, K]) {
+		c.hooks.afterDelete = append(c.hooks.afterDelete, fn)
+	}
+}
+
+// runHooks calls each of the hooks in turn, stopping at the first error.
+func (c CollectionUpdate[D, K]) runHooks(ctx context.Context, hooks []Hook[D], old, new *D) error {
+	for _, hook := range hooks {
+		if err := hook(ctx, c.tx, old, new); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// needsPrevious returns true when writes depend upon the documents they replace.
+func (c CollectionUpdate[D, K]) needsPrevious() bool {
+	return c.hasDerived() || c.hooks.any()
+}
@@ -0,0 +1,183 @@
+package dokvs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/georgemac/dokvs/pkg/keyenc"
+	"github.com/georgemac/dokvs/pkg/kv"
+	"github.com/georgemac/dokvs/pkg/query"
+)
+
+// ErrIndexNotFound is returned when a requested index is not configured
+// on the collection.
+var ErrIndexNotFound = errors.New("index not found")
+
+// Index is a secondary index over a collection of documents of type D.
+// Key derives the indexed value from a document. Documents for which
+// Key returns nil are not indexed.
+type Index[D any] struct {
+	Name string
+	Key  func(D) []byte
+	// Field is the JSON field indexed when the index is configured using
+	// WithFieldIndex. It is used to select indexes when executing queries.
+	Field string
+}
+
+// WithIndex configures a secondary index on a Collection.
+// Index entries are maintained by CollectionUpdate.Put and CollectionUpdate.Delete
+// in a companion keyspace and can be queried using CollectionView.Lookup.
+func WithIndex[D any, K AnyBytes](name string, fn func(D) []byte) func(*Collection[D, K]) {
+	return func(c *Collection[D, K]) {
+		c.indexes = append(c.indexes, Index[D]{Name: name, Key: fn})
+	}
+}
+
+// WithFieldIndex configures a secondary index named after a field of the JSON
+// encoding of each document. Nested fields are separated by dots.
+// Unlike indexes configured with WithIndex, field indexes are used by
+// CollectionView.Query to look up documents by equality on the field.
+func WithFieldIndex[D any, K AnyBytes](field string) func(*Collection[D, K]) {
+	return func(c *Collection[D, K]) {
+		c.indexes = append(c.indexes, Index[D]{Name: field, Field: field, Key: func(d D) []byte {
+			doc, err := jsonDocument(d)
+			if err != nil {
+				return nil
+			}
+
+			v, _ := query.Field(doc, field)
+			return fieldIndexValue(v)
+		}})
+	}
+}
+
+// fieldIndexValue returns the indexed value of a JSON field value.
+// Null and missing values are not indexed.
+func fieldIndexValue(v any) []byte {
+	if v == nil {
+		return nil
+	}
+
+	value, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+
+	return value
+}
+
+// indexKeyspace returns the name of the keyspace which holds the entries
+// of the named index for the provided collection.
+func indexKeyspace(collection []byte, name string) []byte {
+	return []byte(string(collection) + ":index:" + name)
+}
+
+// indexPrefix returns the order-preserving encoding of an indexed value.
+// No encoded value is a prefix of the encoding of another.
+func indexPrefix(value []byte) []byte {
+	return keyenc.Encode(keyenc.Bytes(value))
+}
+
+// indexKey returns the key of an index entry for the provided value and primary key.
+func indexKey(value, pk []byte) []byte {
+	return append(indexPrefix(value), pk...)
+}
+
+func (c Collection[D, K]) indexNamed(name string) (int, bool) {
+	for i, idx := range c.indexes {
+		if idx.Name == name {
+			return i, true
+		}
+	}
+
+	return -1, false
+}
+
+// Lookup returns all the documents for which the named index matches value.
+// Entries whose document is missing are skipped, as on backends whose updates
+// are not transactional (e.g. etcd) an entry may briefly outlive its document.
+func (c CollectionView[D, K]) Lookup(ctx context.Context, index string, value []byte) (ds []D, err error) {
+	i, ok := c.indexNamed(index)
+	if !ok {
+		return nil, fmt.Errorf("index %q: %w", index, ErrIndexNotFound)
+	}
+
+	// the primary key of each entry is the remainder of the key after the value prefix
+	prefix := indexPrefix(value)
+
+	cursor, err := c.indexViews[i].Cursor(ctx, kv.Prefix(prefix), kv.KeysOnly())
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close()
+
+	var keys [][]byte
+	for cursor.Next() {
+		keys = append(keys, cursor.Item().K[len(prefix):])
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	items, err := c.view.Get(ctx, kv.Batch(keys...))
+
+	var berr *kv.BatchError
+	if err != nil && !errors.As(err, &berr) {
+		return nil, err
+	}
+
+	for i := range items {
+		if berr != nil && berr.Errors[i] != nil {
+			if errors.Is(berr.Errors[i], kv.ErrKeyNotFound) {
+				continue
+			}
+
+			return nil, berr.Errors[i]
+		}
+
+		var d D
+		if err = c.decode(keys[i], items[i].V, &d, nil); err != nil {
+			return nil, err
+		}
+
+		ds = append(ds, d)
+	}
+
+	return
+}
+
+// indexChanges accumulates into changes the index entries to remove for old
+// and to insert for new. Either old or new may be nil.
+func (c Collection[D, K]) indexChanges(changes []keyspaceChanges, pk []byte, old, new *D) {
+	for i, idx := range c.indexes {
+		var oldV, newV []byte
+		if old != nil {
+			oldV = idx.Key(*old)
+		}
+
+		if new != nil {
+			newV = idx.Key(*new)
+		}
+
+		if oldV != nil && newV != nil && bytes.Equal(oldV, newV) {
+			continue
+		}
+
+		if oldV != nil {
+			changes[i].delete(indexKey(oldV, pk), pk)
+		}
+
+		if newV != nil {
+			changes[i].put(indexKey(newV, pk), pk)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package dokvs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/georgemac/dokvs/pkg/kv"
+)
+
+// keyspaces returns the name of the primary keyspace of the collection
+// followed by those of its index and unique constraint keyspaces.
+func (c Collection[D, K]) keyspaces() [][]byte {
+	name := c.schema.Collection()
+
+	keyspaces := [][]byte{name}
+	for _, idx := range c.indexes {
+		keyspaces = append(keyspaces, indexKeyspace(name, idx.Name))
+	}
+
+	for _, unique := range uniqueConstraints(c.schema) {
+		keyspaces = append(keyspaces, uniqueKeyspace(name, unique.Name))
+	}
+
+	return keyspaces
+}
+
+// Drop deletes every document in the collection along with its index and
+// unique constraint keyspaces, and removes the collection from the catalog.
+// Dropping a collection which does not exist is not an error.
+func (c Collection[D, K]) Drop(ctx context.Context, update kv.Update) error {
+	for _, keyspace := range c.keyspaces() {
+		if err := update.DeleteKeyspace(ctx, keyspace); err != nil && !errors.Is(err, kv.ErrKeyspaceNotFound) {
+			return err
+		}
+	}
+
+	return unregister(ctx, update, c.schema.Collection())
+}
+
+// Truncate deletes every document in the collection along with the entries
+// of its indexes and unique constraints. The collection remains initialized.
+func (c Collection[D, K]) Truncate(ctx context.Context, update kv.Update) error {
+	for _, keyspace := range c.keyspaces() {
+		if err := update.TruncateKeyspace(ctx, keyspace); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rename moves the collection along with its index and unique constraint
+// keyspaces to the provided name, including its entry in the catalog.
+// It returns the collection configured with the new name.
+//
+// On etcd each keyspace is renamed in its own transaction. So that a failed
+// rename does not leave the collection split across both names, Rename first
+// returns kv.ErrKeyspaceExists when the name is taken by any keyspace holding
+// items or by an entry in the catalog, and should a keyspace then fail to be
+// renamed, those already renamed are moved back before the error is returned.
+//
+// When the serializers of the collection bind values to its name (see
+// EncryptedSerializer), its documents are instead copied to the new name
+// rebound to it, and the originals are deleted once the rename succeeds.
+func (c Collection[D, K]) Rename(ctx context.Context, update kv.Update, name string) (Collection[D, K], error) {
+	renamed := c
+	renamed.schema = renamedSchema[D]{CollectionSchema: c.schema, name: []byte(name)}
+
+	if err := renamed.vacate(ctx, update); err != nil {
+		return c, err
+	}
+
+	from, to := c.keyspaces(), renamed.keyspaces()
+
+	// the documents keyspace is skipped when it is copied
+	first := 0
+	if c.binds() {
+		if err := c.rebind(ctx, update, renamed); err != nil {
+			return c, err
+		}
+
+		first = 1
+	}
+
+	revert := func(n int, err error) error {
+		if first > 0 {
+			_ = update.DeleteKeyspace(ctx, to[0])
+		}
+
+		return revertRename(ctx, update, to[first:n], from[first:n], err)
+	}
+
+	for i := first; i < len(from); i++ {
+		if err := update.RenameKeyspace(ctx, from[i], to[i]); err != nil {
+			return c, revert(i, err)
+		}
+	}
+
+	if err := rename(ctx, update, c.schema.Collection(), []byte(name)); err != nil {
+		return c, revert(len(from), err)
+	}
+
+	if first > 0 {
+		if err := update.DeleteKeyspace(ctx, from[0]); err != nil {
+			_ = rename(ctx, update, []byte(name), c.schema.Collection())
+			return c, revert(len(from), err)
+		}
+	}
+
+	return renamed, nil
+}
+
+// binds reports whether any of the serializers with which the collection
+// reads and writes its documents bind their values to its name.
+func (c Collection[D, K]) binds() bool {
+	if binds(c.serializer) {
+		return true
+	}
+
+	if c.registry != nil {
+		for _, serializer := range c.registry.serializers {
+			if binds(serializer) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// rebind copies the documents of the collection into the documents keyspace
+// of renamed, rebinding each value to the name of renamed. The copy is deleted
+// should it fail.
+func (c Collection[D, K]) rebind(ctx context.Context, update kv.Update, renamed Collection[D, K]) (err error) {
+	source, err := update.Keyspace(c.schema.Collection())
+	if err != nil {
+		return err
+	}
+
+	name := renamed.schema.Collection()
+	if err := update.CreateKeyspace(name); err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = update.DeleteKeyspace(ctx, name)
+		}
+	}()
+
+	destination, err := update.Keyspace(name)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := source.Cursor(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer cursor.Close()
+
+	var items []kv.Item
+	for cursor.Next() {
+		item := cursor.Item()
+
+		v, err := c.rebound(item.K, item.V, renamed)
+		if err != nil {
+			return err
+		}
+
+		items = append(items, kv.Item{K: append([]byte(nil), item.K...), V: v})
+		if len(items) == DefaultMigrateBatchSize {
+			if err := destination.PutMany(ctx, items...); err != nil {
+				return err
+			}
+
+			items = items[:0]
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	if len(items) == 0 {
+		return nil
+	}
+
+	return destination.PutMany(ctx, items...)
+}
+
+// rebound returns the value v stored at key bound to the name of renamed
+// when it was written by a serializer which binds its values.
+func (c Collection[D, K]) rebound(key, v []byte, renamed Collection[D, K]) ([]byte, error) {
+	env, err := decodeEnvelope(v, c.enveloped)
+	if err != nil {
+		return nil, err
+	}
+
+	serializer, err := c.serializerFor(env)
+	if err != nil {
+		return nil, err
+	}
+
+	if !binds(serializer) {
+		return v, nil
+	}
+
+	if env.payload, err = rebind(serializer, env.payload, c.associatedData(key), renamed.associatedData(key)); err != nil {
+		return nil, err
+	}
+
+	return env.encode(), nil
+}
+
+// vacate returns kv.ErrKeyspaceExists when the collection is recorded in the
+// catalog or any of its keyspaces holds items. Otherwise, it deletes any of
+// its keyspaces which exist but are empty so that they can be renamed into.
+func (c Collection[D, K]) vacate(ctx context.Context, update kv.Update) error {
+	name := c.schema.Collection()
+
+	if ok, err := registered(ctx, update, name); err != nil || ok {
+		if ok {
+			err = fmt.Errorf("collection %q: %w", name, kv.ErrKeyspaceExists)
+		}
+
+		return err
+	}
+
+	for _, keyspace := range c.keyspaces() {
+		ks, err := update.Keyspace(keyspace)
+		if err != nil {
+			if errors.Is(err, kv.ErrKeyspaceNotFound) {
+				continue
+			}
+
+			return err
+		}
+
+		n, err := ks.Count(ctx, kv.Limit(1))
+		if err != nil {
+			return err
+		}
+
+		if n > 0 {
+			return fmt.Errorf("keyspace %q: %w", keyspace, kv.ErrKeyspaceExists)
+		}
+
+		if err := update.DeleteKeyspace(ctx, keyspace); err != nil && !errors.Is(err, kv.ErrKeyspaceNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// revertRename moves each of the renamed keyspaces back to its original name,
+// in reverse order and on a best effort basis, before returning err.
+func revertRename(ctx context.Context, update kv.Update, renamed, original [][]byte, err error) error {
+	for i := len(renamed) - 1; i >= 0; i-- {
+		_ = update.RenameKeyspace(ctx, renamed[i], original[i])
+	}
+
+	return err
+}
+
+// renamedSchema overrides the collection name of a schema.
+type renamedSchema[D any] struct {
+	CollectionSchema[D]
+	name []byte
+}
+
+func (s renamedSchema[D]) Collection() []byte { return s.name }
+
+func (s renamedSchema[D]) UniqueConstraints() []UniqueConstraint[D] {
+	return uniqueConstraints(s.CollectionSchema)
+}
@@ -0,0 +1,187 @@
+package dokvs
+
+import (
+	"context"
+	"errors"
+
+	"github.com/georgemac/dokvs/pkg/kv"
+)
+
+// DefaultMigrateBatchSize is the number of documents read in each batch
+// of Migrate when MigrateOptions.BatchSize is not set.
+const DefaultMigrateBatchSize = 100
+
+// Upgrade transforms the serialized form of a document from one version of
+// a schema to the next. It receives and returns the output of the Serializer,
+// or of the Serializer wrapped by a CompressedSerializer or EncryptedSerializer
+// as values are decompressed and decrypted before they are upgraded.
+type Upgrade func([]byte) ([]byte, error)
+
+// WithUpgrade appends an upgrade to the chain configured on a Schema and
+// so increments its version. Upgrades must be supplied in version order
+// and never removed, such that the first upgrades documents from version
+// 1 to 2, the second from 2 to 3 and so on.
+//
+// Documents stored at an older version are upgraded when read and can be
+// rewritten at the current version using Collection.Migrate.
+func WithUpgrade[D any](fn Upgrade) func(*Schema[D]) {
+	return func(s *Schema[D]) {
+		s.upgrades = append(s.upgrades, fn)
+	}
+}
+
+// MigrateOptions configures a call to Collection.Migrate.
+type MigrateOptions struct {
+	// BatchSize is the number of documents read in each transaction.
+	// If BatchSize < 1, DefaultMigrateBatchSize is used.
+	BatchSize int
+	// Token resumes a migration after a checkpoint from a previous call.
+	Token PageToken
+	// Checkpoint, when not nil, is called after each batch is committed with
+	// the token from which to resume the migration should it be interrupted.
+	Checkpoint func(PageToken) error
+}
+
+// Migrate rewrites every document stored at an older version of the schema
+// at the current version and returns the number of documents rewritten.
+// When a registry is configured (see WithRegistry) documents written by
+// other serializers are also rewritten using the configured serializer.
+// Each batch of documents is rewritten in its own update of the store.
+// Documents modified concurrently since they were read are skipped, as any
+// write made by the collection stores the current version.
+// Index entries and unique claims are updated for documents whose indexed
+// or unique values are changed by their upgrades.
+//
+// Documents written before envelopes were introduced are also rewritten
+// within an envelope. Once a migration started without a Token completes,
+// the catalog records that every document of the collection is enveloped
+// (see CollectionDescription.Enveloped).
+func (c Collection[D, K]) Migrate(ctx context.Context, store kv.Store, opts MigrateOptions) (int, error) {
+	n, err := c.rewrite(ctx, store, opts, func(_ []byte, env envelope) (bool, error) {
+		return env.legacy || env.version != c.schema.Version() || c.staleFormat(env), nil
+	})
+	if err != nil || opts.Token != "" {
+		return n, err
+	}
+
+	return n, store.Update(func(tx kv.Update) error {
+		return markEnveloped(ctx, tx, c.schema.Collection())
+	})
+}
+
+// rewrite walks the collection in batches re-encoding the documents
+// whose envelope is reported stale, along with their derived state.
+func (c Collection[D, K]) rewrite(ctx context.Context, store kv.Store, opts MigrateOptions, stale func(key []byte, env envelope) (bool, error)) (n int, err error) {
+	size := opts.BatchSize
+	if size < 1 {
+		size = DefaultMigrateBatchSize
+	}
+
+	var start []byte
+	if opts.Token != "" {
+		if start, err = opts.Token.start(); err != nil {
+			return 0, err
+		}
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+
+		var (
+			read, rewritten int
+			last            []byte
+		)
+
+		err := store.Update(func(tx kv.Update) error {
+			update, err := c.Update(tx)
+			if err != nil {
+				return err
+			}
+
+			items, err := update.update.Range(ctx, kv.Start(start), kv.Limit(size))
+			if err != nil {
+				return err
+			}
+
+			for _, item := range items {
+				env, err := decodeEnvelope(item.V, update.enveloped)
+				if err != nil {
+					return err
+				}
+
+				if ok, err := stale(item.K, env); err != nil {
+					return err
+				} else if !ok {
+					continue
+				}
+
+				var d D
+				if err := c.decode(item.K, item.V, &d, nil); err != nil {
+					return err
+				}
+
+				v, err := c.encode(item.K, d)
+				if err != nil {
+					return err
+				}
+
+				changes := update.newDerivedChanges()
+				update.derive(changes, item.K, c.written(item.K, env, &d), &d)
+
+				undo, err := update.applyUnique(ctx, changes)
+				if err != nil {
+					return err
+				}
+
+				if err := update.update.Put(ctx, item.K, v, kv.IfVersion(item.Version)); err != nil {
+					undo()
+
+					if errors.Is(err, kv.ErrConflict) {
+						continue
+					}
+
+					return err
+				}
+
+				if err := update.settleDerived(ctx, changes); err != nil {
+					return err
+				}
+
+				rewritten++
+			}
+
+			if read = len(items); read > 0 {
+				// retain the key beyond the lifetime of the transaction
+				last = append([]byte(nil), items[read-1].K...)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+
+		n += rewritten
+
+		if read == 0 {
+			return n, nil
+		}
+
+		token := newPageToken(last)
+		if opts.Checkpoint != nil {
+			if err := opts.Checkpoint(token); err != nil {
+				return n, err
+			}
+		}
+
+		if read < size {
+			return n, nil
+		}
+
+		if start, err = token.start(); err != nil {
+			return n, err
+		}
+	}
+}
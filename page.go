@@ -0,0 +1,41 @@
+package dokvs
+
+import (
+	"encoding/base64"
+	"errors"
+)
+
+// DefaultPageSize is the maximum number of documents returned by List
+// when ListPredicate.Limit is not set.
+const DefaultPageSize = 100
+
+// ErrInvalidPageToken is returned when a PageToken cannot be decoded.
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// PageToken is an opaque token which identifies where the next page of a List begins.
+// The zero value refers to the beginning of a collection.
+type PageToken string
+
+// Page is a single page of documents returned by List.
+type Page[D any] struct {
+	Documents []D
+	// Next is the token to supply in ListPredicate.Token to fetch the
+	// following page. It is empty when there are no further documents.
+	Next PageToken
+}
+
+// newPageToken returns a token which resumes a List exclusively after key.
+func newPageToken(key []byte) PageToken {
+	return PageToken(base64.RawURLEncoding.EncodeToString(key))
+}
+
+// start returns the inclusive key from which the next page begins.
+func (t PageToken) start() ([]byte, error) {
+	key, err := base64.RawURLEncoding.DecodeString(string(t))
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+
+	// the smallest key which sorts after the last key of the previous page
+	return append(key, 0x00), nil
+}
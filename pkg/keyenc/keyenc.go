@@ -0,0 +1,262 @@
+// Package keyenc implements an order-preserving encoding of typed tuples as keys.
+//
+// Keys produced by Encode compare bytewise (as they do in both bolt and etcd) in
+// the same order as their elements compare semantically, element by element.
+// For example, Encode(Int64(-1)) sorts before Encode(Int64(2)) and
+// Encode(String("a"), Int64(10)) sorts before Encode(String("b"), Int64(1)).
+//
+// Encoding a prefix of a tuple produces a byte prefix of the encoding of the
+// whole tuple, which allows partial keys to be used in prefix ranges.
+// The encoding of an element is never a byte prefix of the encoding of a
+// different element, so a prefix range over a partial key matches exactly
+// the keys whose leading elements are equal to those of the partial key.
+package keyenc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidKey is returned when a key cannot be decoded.
+var ErrInvalidKey = errors.New("invalid key")
+
+// type codes which prefix each encoded element
+// these define the relative order of elements of differing types
+const (
+	codeEnd    byte = 0x00
+	codeBytes  byte = 0x01
+	codeString byte = 0x02
+	codeInt64  byte = 0x03
+	codeUint64 byte = 0x04
+	codeTime   byte = 0x05
+	codeTuple  byte = 0x06
+)
+
+// escape bytes used to encode zero bytes within bytes and string elements
+// a zero byte is encoded as 0x00 0xFF and the element is terminated by 0x00 0x01,
+// so that no encoded value is a prefix of another and shorter values sort first
+const (
+	escapeByte  byte = 0x00
+	escapedNull byte = 0xFF
+	escapedEnd  byte = 0x01
+)
+
+// Key is an encoded tuple of elements.
+type Key []byte
+
+// Element is a single typed member of a tuple.
+type Element interface {
+	appendTo([]byte) []byte
+}
+
+// Encode returns the order-preserving encoding of the tuple of elements.
+func Encode(elems ...Element) Key {
+	var key Key
+	for _, elem := range elems {
+		key = elem.appendTo(key)
+	}
+
+	return key
+}
+
+// Append returns the encoding of the tuple extended with elems.
+func (k Key) Append(elems ...Element) Key {
+	key := append(Key(nil), k...)
+	for _, elem := range elems {
+		key = elem.appendTo(key)
+	}
+
+	return key
+}
+
+type bytesElement []byte
+
+// Bytes returns an Element for a byte slice.
+// Byte slices are ordered lexicographically.
+func Bytes(b []byte) Element { return bytesElement(b) }
+
+func (e bytesElement) appendTo(dst []byte) []byte {
+	return appendEscaped(append(dst, codeBytes), e)
+}
+
+type stringElement string
+
+// String returns an Element for a string.
+// Strings are ordered lexicographically by their UTF-8 encoding.
+func String(s string) Element { return stringElement(s) }
+
+func (e stringElement) appendTo(dst []byte) []byte {
+	return appendEscaped(append(dst, codeString), []byte(e))
+}
+
+// appendEscaped appends src with each zero byte escaped followed by a terminator.
+func appendEscaped(dst, src []byte) []byte {
+	for _, b := range src {
+		dst = append(dst, b)
+		if b == escapeByte {
+			dst = append(dst, escapedNull)
+		}
+	}
+
+	return append(dst, escapeByte, escapedEnd)
+}
+
+type int64Element int64
+
+// Int64 returns an Element for a signed integer.
+func Int64(v int64) Element { return int64Element(v) }
+
+func (e int64Element) appendTo(dst []byte) []byte {
+	return appendUint64(append(dst, codeInt64), flipSign(int64(e)))
+}
+
+type uint64Element uint64
+
+// Uint64 returns an Element for an unsigned integer.
+func Uint64(v uint64) Element { return uint64Element(v) }
+
+func (e uint64Element) appendTo(dst []byte) []byte {
+	return appendUint64(append(dst, codeUint64), uint64(e))
+}
+
+type timeElement time.Time
+
+// Time returns an Element for an instant in time with nanosecond precision.
+// Only the instant is encoded: the location is not preserved and decoded times are in UTC.
+// Times must be representable as int64 nanoseconds since the Unix epoch
+// (between the years 1678 and 2262).
+func Time(t time.Time) Element { return timeElement(t) }
+
+func (e timeElement) appendTo(dst []byte) []byte {
+	return appendUint64(append(dst, codeTime), flipSign(time.Time(e).UnixNano()))
+}
+
+type tupleElement []Element
+
+// Tuple returns an Element for a nested tuple of elements.
+// Nested tuples are ordered element by element and a tuple sorts
+// before any tuple which it is a prefix of.
+func Tuple(elems ...Element) Element { return tupleElement(elems) }
+
+func (e tupleElement) appendTo(dst []byte) []byte {
+	dst = append(dst, codeTuple)
+	for _, elem := range e {
+		dst = elem.appendTo(dst)
+	}
+
+	return append(dst, codeEnd)
+}
+
+// flipSign maps int64 onto uint64 such that the big-endian
+// encoding of the result preserves the order of signed values.
+func flipSign(v int64) uint64 {
+	return uint64(v) ^ (1 << 63)
+}
+
+func appendUint64(dst []byte, v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return append(dst, b[:]...)
+}
+
+// Decode returns the elements of the tuple encoded in key.
+// Elements are decoded as []byte, string, int64, uint64, time.Time
+// and nested tuples as []any.
+func Decode(key []byte) ([]any, error) {
+	elems, rest, err := decodeTuple(key, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrInvalidKey, len(rest))
+	}
+
+	return elems, nil
+}
+
+// decodeTuple decodes elements until the input is exhausted or,
+// when nested, until a terminating codeEnd is consumed.
+func decodeTuple(src []byte, nested bool) (elems []any, rest []byte, err error) {
+	for len(src) > 0 {
+		code := src[0]
+		src = src[1:]
+
+		var elem any
+		switch code {
+		case codeEnd:
+			if nested {
+				return elems, src, nil
+			}
+
+			return nil, nil, fmt.Errorf("%w: unexpected end of tuple", ErrInvalidKey)
+		case codeBytes:
+			elem, src, err = decodeEscaped(src)
+		case codeString:
+			var b []byte
+			b, src, err = decodeEscaped(src)
+			elem = string(b)
+		case codeInt64, codeUint64, codeTime:
+			if len(src) < 8 {
+				return nil, nil, fmt.Errorf("%w: truncated integer", ErrInvalidKey)
+			}
+
+			v := binary.BigEndian.Uint64(src)
+			src = src[8:]
+
+			switch code {
+			case codeInt64:
+				elem = int64(v ^ (1 << 63))
+			case codeUint64:
+				elem = v
+			default:
+				elem = time.Unix(0, int64(v^(1<<63))).UTC()
+			}
+		case codeTuple:
+			elem, src, err = decodeTuple(src, true)
+		default:
+			return nil, nil, fmt.Errorf("%w: unknown type code 0x%02x", ErrInvalidKey, code)
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		elems = append(elems, elem)
+	}
+
+	if nested {
+		return nil, nil, fmt.Errorf("%w: unterminated tuple", ErrInvalidKey)
+	}
+
+	return elems, nil, nil
+}
+
+// decodeEscaped decodes an escaped and terminated byte sequence.
+func decodeEscaped(src []byte) (dst, rest []byte, err error) {
+	dst = []byte{}
+	for i := 0; i < len(src); i++ {
+		if src[i] != escapeByte {
+			dst = append(dst, src[i])
+			continue
+		}
+
+		if i+1 >= len(src) {
+			break
+		}
+
+		switch src[i+1] {
+		case escapedNull:
+			dst = append(dst, escapeByte)
+			i++
+		case escapedEnd:
+			return dst, src[i+2:], nil
+		default:
+			return nil, nil, fmt.Errorf("%w: invalid escape 0x%02x", ErrInvalidKey, src[i+1])
+		}
+	}
+
+	return nil, nil, fmt.Errorf("%w: unterminated bytes", ErrInvalidKey)
+}
@@ -0,0 +1,127 @@
+package keyenc
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode_PreservesOrder(t *testing.T) {
+	epoch := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// each set of keys is in ascending semantic order
+	for _, test := range []struct {
+		name string
+		keys []Key
+	}{
+		{
+			name: "int64",
+			keys: []Key{
+				Encode(Int64(-1 << 63)),
+				Encode(Int64(-100)),
+				Encode(Int64(-1)),
+				Encode(Int64(0)),
+				Encode(Int64(2)),
+				Encode(Int64(256)),
+				Encode(Int64(1<<63 - 1)),
+			},
+		},
+		{
+			name: "uint64",
+			keys: []Key{
+				Encode(Uint64(0)),
+				Encode(Uint64(9)),
+				Encode(Uint64(10)),
+				Encode(Uint64(1<<64 - 1)),
+			},
+		},
+		{
+			name: "time",
+			keys: []Key{
+				Encode(Time(epoch.Add(-time.Hour))),
+				Encode(Time(epoch)),
+				Encode(Time(epoch.Add(time.Nanosecond))),
+				Encode(Time(epoch.AddDate(1, 0, 0))),
+			},
+		},
+		{
+			name: "strings",
+			keys: []Key{
+				Encode(String("")),
+				Encode(String("a")),
+				Encode(String("a\x00")),
+				Encode(String("a\x00b")),
+				Encode(String("a\x01")),
+				Encode(String("ab")),
+				Encode(String("b")),
+			},
+		},
+		{
+			name: "tuples",
+			keys: []Key{
+				Encode(String("a")),
+				Encode(String("a"), Int64(-5)),
+				Encode(String("a"), Int64(10)),
+				Encode(String("a"), Int64(10), String("z")),
+				Encode(String("b"), Int64(1)),
+				Encode(String("b"), Tuple(Int64(1)), String("a")),
+				Encode(String("b"), Tuple(Int64(1), Int64(0))),
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for i := 1; i < len(test.keys); i++ {
+				assert.Equal(t, -1, bytes.Compare(test.keys[i-1], test.keys[i]), "keys %d and %d", i-1, i)
+			}
+		})
+	}
+}
+
+func TestEncode_PrefixProperty(t *testing.T) {
+	prefix := Encode(String("tenant"), Uint64(7))
+	key := prefix.Append(String("id"))
+
+	assert.True(t, bytes.HasPrefix(key, prefix))
+	assert.False(t, bytes.HasPrefix(Encode(String("tenant2")), Encode(String("tenant"))))
+	assert.False(t, bytes.HasPrefix(Encode(String("tenant\x00x")), Encode(String("tenant"))))
+	assert.False(t, bytes.HasPrefix(Encode(Bytes([]byte("a\x00"))), Encode(Bytes([]byte("a")))))
+}
+
+func TestDecode(t *testing.T) {
+	at := time.Date(2022, 2, 3, 4, 5, 6, 7, time.UTC)
+
+	key := Encode(
+		Bytes([]byte{0x00, 0x01, 0xFF}),
+		String("hello\x00world"),
+		Int64(-42),
+		Uint64(42),
+		Time(at),
+		Tuple(String("nested"), Int64(1)),
+	)
+
+	elems, err := Decode(key)
+	require.NoError(t, err)
+
+	assert.Equal(t, []any{
+		[]byte{0x00, 0x01, 0xFF},
+		"hello\x00world",
+		int64(-42),
+		uint64(42),
+		at,
+		[]any{"nested", int64(1)},
+	}, elems)
+
+	for _, invalid := range [][]byte{
+		{codeString, 'a'},
+		{codeInt64, 0x00},
+		{codeTuple, codeString, 'a', codeEnd},
+		{0x7F},
+		{codeEnd},
+	} {
+		_, err := Decode(invalid)
+		assert.ErrorIs(t, err, ErrInvalidKey, "%x", invalid)
+	}
+}
@@ -3,6 +3,7 @@ package boltdb
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 
@@ -10,10 +11,10 @@ import (
 	bolt "go.etcd.io/bbolt"
 )
 
-const defaultLimit = 100
-
 var (
-	ErrBucketNotExist = errors.New("bucket not exist")
+	// ErrBucketNotExist is returned when a keyspace does not exist.
+	// It is equivalent to kv.ErrKeyspaceNotFound.
+	ErrBucketNotExist = kv.ErrKeyspaceNotFound
 
 	_ kv.Store = (*KV)(nil)
 )
@@ -43,15 +44,19 @@ type View struct {
 func (v View) Keyspace(key []byte) (_ kv.KeyspaceView, err error) {
 	view := KeyspaceView{}
 	if view.bucket = v.tx.Bucket(key); view.bucket == nil {
-		err = fmt.Errorf("keyspace %q: ", ErrBucketNotExist)
+		err = fmt.Errorf("keyspace %q: %w", key, ErrBucketNotExist)
 		return
 	}
 
+	view.versioned = isVersioned(v.tx, key)
+
 	return view, nil
 }
 
 type KeyspaceView struct {
 	bucket *bolt.Bucket
+	// versioned is true when the values of the bucket carry version headers
+	versioned bool
 }
 
 func (k KeyspaceView) Get(_ context.Context, opts kv.GetOptions) (items []kv.Item, err error) {
@@ -60,7 +65,7 @@ func (k KeyspaceView) Get(_ context.Context, opts kv.GetOptions) (items []kv.Ite
 
 	for i := range opts.Keys {
 		items[i].K = opts.Keys[i]
-		if items[i].V = k.bucket.Get(opts.Keys[i]); items[i].V == nil {
+		if items[i].Version, items[i].V = k.decodeValue(k.bucket.Get(opts.Keys[i])); items[i].V == nil {
 			if berr == nil {
 				berr = &kv.BatchError{
 					Errors: make([]error, len(opts.Keys)),
@@ -87,22 +92,38 @@ func (k KeyspaceView) Range(_ context.Context, opts ...kv.RangeOption) (items []
 		opt(&rng)
 	}
 
-	if rng.Limit < 1 {
-		rng.Limit = defaultLimit
+	if rng.CountOnly {
+		return nil, nil
 	}
 
-	cursor := k.bucket.Cursor()
+	cursor := k.cursor(rng)
 
-	key, value := cursor.First()
-	if rng.Start != nil {
-		key, value = cursor.Seek(rng.Start)
+	move := cursor.Next
+	if rng.Reverse {
+		move = cursor.Prev
 	}
 
-	for ; key != nil && (rng.End == nil || bytes.Compare(key, rng.End) < 0); key, value = cursor.Next() {
-		if len(items) >= rng.Limit {
+	for move() {
+		if rng.Limit > 0 && len(items) >= rng.Limit {
 			break
 		}
-		items = append(items, kv.Item{K: key, V: value})
+
+		items = append(items, cursor.Item())
+	}
+
+	return
+}
+
+func (k KeyspaceView) Count(_ context.Context, opts ...kv.RangeOption) (n int, err error) {
+	var rng kv.RangeOptions
+	for _, opt := range opts {
+		opt(&rng)
+	}
+
+	// values are never read when counting
+	rng.KeysOnly = true
+
+	for cursor := k.cursor(rng); (rng.Limit < 1 || n < rng.Limit) && cursor.Next(); n++ {
 	}
 
 	return
@@ -120,16 +141,117 @@ type Update struct {
 
 func (u Update) CreateKeyspace(key []byte) error {
 	_, err := u.tx.CreateBucket(key)
-	return err
+	if errors.Is(err, bolt.ErrBucketExists) {
+		return fmt.Errorf("keyspace %q: %w", key, kv.ErrKeyspaceExists)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return setVersioned(u.tx, key, true)
+}
+
+func (u Update) DeleteKeyspace(_ context.Context, key []byte) error {
+	err := u.tx.DeleteBucket(key)
+	if errors.Is(err, bolt.ErrBucketNotFound) {
+		return fmt.Errorf("keyspace %q: %w", key, kv.ErrKeyspaceNotFound)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return setVersioned(u.tx, key, false)
+}
+
+// TruncateKeyspace recreates the bucket retaining its sequence, such that
+// the versions of items put after truncation continue to increase.
+func (u Update) TruncateKeyspace(ctx context.Context, key []byte) error {
+	bucket := u.tx.Bucket(key)
+	if bucket == nil {
+		return fmt.Errorf("keyspace %q: %w", key, kv.ErrKeyspaceNotFound)
+	}
+
+	sequence := bucket.Sequence()
+	if err := u.tx.DeleteBucket(key); err != nil {
+		return err
+	}
+
+	bucket, err := u.tx.CreateBucket(key)
+	if err != nil {
+		return err
+	}
+
+	if err := bucket.SetSequence(sequence); err != nil {
+		return err
+	}
+
+	// the bucket is empty, so it holds no values in the legacy format
+	return setVersioned(u.tx, key, true)
 }
 
+// RenameKeyspace copies every item and the sequence of the bucket into a
+// new bucket before deleting the original, all within the current transaction.
+func (u Update) RenameKeyspace(_ context.Context, from, to []byte) error {
+	src := u.tx.Bucket(from)
+	if src == nil {
+		return fmt.Errorf("keyspace %q: %w", from, kv.ErrKeyspaceNotFound)
+	}
+
+	if u.tx.Bucket(to) != nil {
+		return fmt.Errorf("keyspace %q: %w", to, kv.ErrKeyspaceExists)
+	}
+
+	if err := upgradeBucket(u.tx, from, src); err != nil {
+		return err
+	}
+
+	dst, err := u.tx.CreateBucket(to)
+	if err != nil {
+		if errors.Is(err, bolt.ErrBucketExists) {
+			return fmt.Errorf("keyspace %q: %w", to, kv.ErrKeyspaceExists)
+		}
+
+		return err
+	}
+
+	// stored values retain their version headers
+	if err := src.ForEach(dst.Put); err != nil {
+		return err
+	}
+
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+
+	if err := setVersioned(u.tx, to, true); err != nil {
+		return err
+	}
+
+	if err := u.tx.DeleteBucket(from); err != nil {
+		return err
+	}
+
+	return setVersioned(u.tx, from, false)
+}
+
+// Keyspace returns the named keyspace for update. Buckets which hold
+// values written before versions were introduced are first upgraded
+// in place (see upgradeBucket).
 func (u Update) Keyspace(key []byte) (_ kv.KeyspaceUpdate, err error) {
 	update := KeyspaceUpdate{}
 	if update.bucket = u.tx.Bucket(key); update.bucket == nil {
-		err = fmt.Errorf("keyspace %q: ", ErrBucketNotExist)
+		err = fmt.Errorf("keyspace %q: %w", key, ErrBucketNotExist)
 		return
 	}
 
+	if err := upgradeBucket(u.tx, key, update.bucket); err != nil {
+		return nil, err
+	}
+
+	update.versioned = true
+
 	return update, nil
 }
 
@@ -143,10 +265,180 @@ func (u KeyspaceUpdate) Range(ctx context.Context, opts ...kv.RangeOption) ([]kv
 	return KeyspaceView(u).Range(ctx, opts...)
 }
 
-func (u KeyspaceUpdate) Put(_ context.Context, k, v []byte) error {
-	return u.bucket.Put(k, v)
+func (u KeyspaceUpdate) Count(ctx context.Context, opts ...kv.RangeOption) (int, error) {
+	return KeyspaceView(u).Count(ctx, opts...)
+}
+
+func (u KeyspaceUpdate) Put(_ context.Context, k, v []byte, opts ...kv.PutOption) error {
+	var put kv.PutOptions
+	for _, opt := range opts {
+		opt(&put)
+	}
+
+	if put.Conditional() {
+		version, _ := KeyspaceView(u).decodeValue(u.bucket.Get(k))
+		if err := put.Check(version); err != nil {
+			return err
+		}
+	}
+
+	version, err := u.bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	return u.bucket.Put(k, encodeValue(int64(version), v))
 }
 
 func (u KeyspaceUpdate) Delete(_ context.Context, k []byte) error {
 	return u.bucket.Delete(k)
 }
+
+// DeleteRange deletes every key within the bounds of the range
+// and returns the number of keys deleted.
+func (u KeyspaceUpdate) DeleteRange(_ context.Context, opts ...kv.RangeOption) (n int, err error) {
+	var rng kv.RangeOptions
+	for _, opt := range opts {
+		opt(&rng)
+	}
+
+	start, end := rng.Bounds()
+
+	cursor := u.bucket.Cursor()
+
+	key, _ := cursor.First()
+	if start != nil {
+		key, _ = cursor.Seek(start)
+	}
+
+	for key != nil && (end == nil || bytes.Compare(key, end) < 0) {
+		// retain the key as the memory it refers to is released by the delete
+		deleted := append([]byte(nil), key...)
+		if err := cursor.Delete(); err != nil {
+			return n, err
+		}
+
+		n++
+
+		// seek past the deleted key, as bolt cursors are not
+		// guaranteed to retain their position across deletes
+		key, _ = cursor.Seek(deleted)
+	}
+
+	return n, nil
+}
+
+// PutMany puts each of the items within the current transaction.
+func (u KeyspaceUpdate) PutMany(ctx context.Context, items ...kv.Item) error {
+	for _, item := range items {
+		if err := u.Put(ctx, item.K, item.V); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteMany deletes each of the keys within the current transaction.
+func (u KeyspaceUpdate) DeleteMany(ctx context.Context, keys ...[]byte) error {
+	for _, key := range keys {
+		if err := u.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// versionSize is the length of the version header prefixed to each stored value.
+const versionSize = 8
+
+// versionedBucket is the root bucket which records the names of the buckets
+// whose values are prefixed with a version header. Buckets which are not
+// recorded were written before versions were introduced and hold raw values.
+var versionedBucket = []byte("_dokvs_versioned")
+
+// isVersioned returns true when the values of the named bucket carry version headers.
+func isVersioned(tx *bolt.Tx, name []byte) bool {
+	versioned := tx.Bucket(versionedBucket)
+	return versioned != nil && versioned.Get(name) != nil
+}
+
+// setVersioned records whether the values of the named bucket carry version headers.
+func setVersioned(tx *bolt.Tx, name []byte, versioned bool) error {
+	if !versioned {
+		if bucket := tx.Bucket(versionedBucket); bucket != nil {
+			return bucket.Delete(name)
+		}
+
+		return nil
+	}
+
+	bucket, err := tx.CreateBucketIfNotExists(versionedBucket)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put(name, []byte{1})
+}
+
+// upgradeBucket rewrites every raw value of a bucket written before versions
+// were introduced with a version header drawn from the sequence of the bucket.
+// It is a noop for buckets which are already versioned.
+func upgradeBucket(tx *bolt.Tx, name []byte, bucket *bolt.Bucket) error {
+	if isVersioned(tx, name) {
+		return nil
+	}
+
+	// values are collected before they are rewritten as bolt
+	// does not permit modifying a bucket during iteration
+	var items []kv.Item
+	if err := bucket.ForEach(func(k, v []byte) error {
+		// nested buckets have nil values and are left untouched
+		if v != nil {
+			items = append(items, kv.Item{
+				K: append([]byte(nil), k...),
+				V: append([]byte(nil), v...),
+			})
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		version, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		if err := bucket.Put(item.K, encodeValue(int64(version), item.V)); err != nil {
+			return err
+		}
+	}
+
+	return setVersioned(tx, name, true)
+}
+
+// encodeValue prefixes v with the big-endian encoding of version.
+// Versions are drawn from the sequence of the bucket and so increase
+// with every put, including puts which recreate deleted keys.
+func encodeValue(version int64, v []byte) []byte {
+	value := make([]byte, versionSize+len(v))
+	binary.BigEndian.PutUint64(value, uint64(version))
+	copy(value[versionSize:], v)
+	return value
+}
+
+// decodeValue returns the version and value of a stored value.
+// It returns a version of zero and a nil value when raw is nil.
+// Values of buckets which are not yet versioned are returned as they
+// are stored with a version of zero, until the bucket is first updated.
+func (k KeyspaceView) decodeValue(raw []byte) (int64, []byte) {
+	if !k.versioned || len(raw) < versionSize {
+		return 0, raw
+	}
+
+	return int64(binary.BigEndian.Uint64(raw)), raw[versionSize:]
+}
@@ -1,11 +1,13 @@
 package boltdb
 
 import (
+	"context"
 	"os"
 	"testing"
 
 	"github.com/georgemac/dokvs/pkg/kv"
 	kvtesting "github.com/georgemac/dokvs/pkg/kv/testing"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	bolt "go.etcd.io/bbolt"
 )
@@ -32,6 +34,69 @@ func TestBoltDB_KVStore_TestingHarness(t *testing.T) {
 	})
 }
 
+func TestBoltDB_LegacyValues(t *testing.T) {
+	db, cleanup := newBoltDB("legacy.bolt")
+	t.Cleanup(cleanup)
+
+	var (
+		ctx   = context.Background()
+		keys  = kv.Batch([]byte("a"), []byte("b"))
+		store = New(db)
+	)
+
+	// values written before versions were introduced are stored raw
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucket([]byte("legacy"))
+		require.NoError(t, err)
+
+		require.NoError(t, bkt.Put([]byte("a"), []byte("ab")))
+		return bkt.Put([]byte("b"), []byte("a longer value"))
+	}))
+
+	require.NoError(t, store.View(func(tx kv.View) error {
+		keyspace, err := tx.Keyspace([]byte("legacy"))
+		require.NoError(t, err)
+
+		items, err := keyspace.Get(ctx, keys)
+		require.NoError(t, err)
+		assert.Equal(t, []kv.Item{
+			{K: []byte("a"), V: []byte("ab")},
+			{K: []byte("b"), V: []byte("a longer value")},
+		}, items)
+
+		return nil
+	}))
+
+	require.NoError(t, store.Update(func(tx kv.Update) error {
+		keyspace, err := tx.Keyspace([]byte("legacy"))
+		require.NoError(t, err)
+
+		items, err := keyspace.Get(ctx, keys)
+		require.NoError(t, err)
+		require.Len(t, items, 2)
+
+		for i, expected := range []string{"ab", "a longer value"} {
+			assert.Equal(t, expected, string(items[i].V))
+			assert.Greater(t, items[i].Version, int64(0))
+		}
+
+		return keyspace.Put(ctx, []byte("a"), []byte("cd"), kv.IfVersion(items[0].Version))
+	}))
+
+	require.NoError(t, store.View(func(tx kv.View) error {
+		keyspace, err := tx.Keyspace([]byte("legacy"))
+		require.NoError(t, err)
+
+		items, err := keyspace.Range(ctx)
+		require.NoError(t, err)
+		require.Len(t, items, 2)
+		assert.Equal(t, "cd", string(items[0].V))
+		assert.Equal(t, "a longer value", string(items[1].V))
+
+		return nil
+	}))
+}
+
 func newBoltDB(path string) (*bolt.DB, func()) {
 	db, err := bolt.Open(path, 0666, nil)
 	if err != nil {
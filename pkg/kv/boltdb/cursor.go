@@ -0,0 +1,119 @@
+package boltdb
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/georgemac/dokvs/pkg/kv"
+	bolt "go.etcd.io/bbolt"
+)
+
+var _ kv.Cursor = (*Cursor)(nil)
+
+// Cursor is a kv.Cursor which wraps a *bolt.Cursor bounded to a range.
+type Cursor struct {
+	cursor     *bolt.Cursor
+	view       KeyspaceView
+	start, end []byte
+	keysOnly   bool
+
+	positioned, exhausted bool
+	item                  kv.Item
+}
+
+func (k KeyspaceView) Cursor(_ context.Context, opts ...kv.RangeOption) (kv.Cursor, error) {
+	var rng kv.RangeOptions
+	for _, opt := range opts {
+		opt(&rng)
+	}
+
+	return k.cursor(rng), nil
+}
+
+func (k KeyspaceView) cursor(rng kv.RangeOptions) *Cursor {
+	start, end := rng.Bounds()
+
+	return &Cursor{
+		cursor:   k.bucket.Cursor(),
+		view:     k,
+		start:    start,
+		end:      end,
+		keysOnly: rng.KeysOnly,
+	}
+}
+
+func (u KeyspaceUpdate) Cursor(ctx context.Context, opts ...kv.RangeOption) (kv.Cursor, error) {
+	return KeyspaceView(u).Cursor(ctx, opts...)
+}
+
+func (c *Cursor) Seek(key []byte) bool {
+	if c.start != nil && bytes.Compare(key, c.start) < 0 {
+		key = c.start
+	}
+
+	if key == nil {
+		return c.set(c.cursor.First())
+	}
+
+	return c.set(c.cursor.Seek(key))
+}
+
+func (c *Cursor) Next() bool {
+	if c.exhausted {
+		return false
+	}
+
+	if !c.positioned {
+		return c.Seek(c.start)
+	}
+
+	return c.set(c.cursor.Next())
+}
+
+func (c *Cursor) Prev() bool {
+	if c.exhausted {
+		return false
+	}
+
+	if c.positioned {
+		return c.set(c.cursor.Prev())
+	}
+
+	if c.end == nil {
+		return c.set(c.cursor.Last())
+	}
+
+	// position on the first key >= end and step back into range
+	if key, _ := c.cursor.Seek(c.end); key == nil {
+		return c.set(c.cursor.Last())
+	}
+
+	return c.set(c.cursor.Prev())
+}
+
+func (c *Cursor) set(key, value []byte) bool {
+	if key == nil ||
+		(c.start != nil && bytes.Compare(key, c.start) < 0) ||
+		(c.end != nil && bytes.Compare(key, c.end) >= 0) {
+		c.positioned, c.exhausted = false, true
+		c.item = kv.Item{}
+		return false
+	}
+
+	version, value := c.view.decodeValue(value)
+	if c.keysOnly {
+		value = nil
+	}
+
+	c.positioned, c.exhausted = true, false
+	c.item = kv.Item{K: key, V: value, Version: version}
+	return true
+}
+
+func (c *Cursor) Item() kv.Item { return c.item }
+
+// Err always returns nil as bolt cursors do not fail once created.
+func (c *Cursor) Err() error { return nil }
+
+// Close is a noop for bolt as cursors are released with their transaction.
+func (c *Cursor) Close() error { return nil }
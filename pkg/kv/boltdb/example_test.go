@@ -22,7 +22,7 @@ type Recipe struct {
 	ID ID
 }
 
-func Example_Bolt_Collection() {
+func Example_collection() {
 	recipes := dokvs.NewCollection[Recipe, ID](schema, dokvs.WithSerializer[Recipe, ID](serializer))
 
 	ctx := context.Background()
@@ -46,7 +46,7 @@ func Example_Bolt_Collection() {
 			return err
 		}
 
-		recipe, err := recipes.Fetch(ctx, ID("my_recipe"))
+		recipe, _, err := recipes.Fetch(ctx, ID("my_recipe"))
 		if err != nil {
 			return err
 		}
@@ -57,12 +57,12 @@ func Example_Bolt_Collection() {
 			return err
 		}
 
-		allRecipes, err := recipes.List(ctx, dokvs.ListPredicate{})
+		allRecipes, err := recipes.List(ctx, dokvs.ListPredicate[Recipe]{})
 		if err != nil {
 			return err
 		}
 
-		fmt.Printf("%#v\n", allRecipes)
+		fmt.Printf("%#v\n", allRecipes.Documents)
 
 		return nil
 	}); err != nil {
@@ -0,0 +1,176 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/georgemac/dokvs/pkg/kv"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+var _ kv.Cursor = (*Cursor)(nil)
+
+// Cursor is a kv.Cursor which lazily pages through a range of keys in etcd.
+// All pages are read at the revision observed by the first request made,
+// so the cursor iterates a consistent snapshot of the range.
+type Cursor struct {
+	ctx      context.Context
+	view     KeyspaceView
+	pageSize int64
+
+	// start and end are the bounds of the range as prefixed etcd keys
+	start, end string
+	rev        int64
+	keysOnly   bool
+
+	page       []*mvccpb.KeyValue
+	idx        int
+	positioned bool
+	exhausted  bool
+	err        error
+}
+
+func (k KeyspaceView) Cursor(ctx context.Context, opts ...kv.RangeOption) (kv.Cursor, error) {
+	var rng kv.RangeOptions
+	for _, opt := range opts {
+		opt(&rng)
+	}
+
+	return k.cursor(ctx, rng), nil
+}
+
+func (k KeyspaceView) cursor(ctx context.Context, rng kv.RangeOptions) *Cursor {
+	start, end := k.bounds(rng)
+
+	return &Cursor{
+		ctx:      ctx,
+		view:     k,
+		pageSize: k.config.cursorPageSize,
+		start:    start,
+		end:      end,
+		keysOnly: rng.KeysOnly,
+	}
+}
+
+func (u KeyspaceUpdate) Cursor(ctx context.Context, opts ...kv.RangeOption) (kv.Cursor, error) {
+	return KeyspaceView(u).Cursor(ctx, opts...)
+}
+
+func (c *Cursor) Seek(key []byte) bool {
+	from := c.view.key(key)
+	if from < c.start {
+		from = c.start
+	}
+
+	return c.forward(from)
+}
+
+func (c *Cursor) Next() bool {
+	if c.exhausted {
+		return false
+	}
+
+	if !c.positioned {
+		return c.forward(c.start)
+	}
+
+	if c.idx+1 < len(c.page) {
+		c.idx++
+		return true
+	}
+
+	// continue from the key immediately following the current key
+	return c.forward(string(c.page[c.idx].Key) + "\x00")
+}
+
+func (c *Cursor) Prev() bool {
+	if c.exhausted {
+		return false
+	}
+
+	if !c.positioned {
+		return c.backward(c.end)
+	}
+
+	if c.idx > 0 {
+		c.idx--
+		return true
+	}
+
+	return c.backward(string(c.page[c.idx].Key))
+}
+
+// forward fetches the page of keys in [from, end).
+func (c *Cursor) forward(from string) bool {
+	return c.fetch(from, c.end, clientv3.SortAscend)
+}
+
+// backward fetches the page of keys in [start, to) in descending order.
+func (c *Cursor) backward(to string) bool {
+	if !c.fetch(c.start, to, clientv3.SortDescend) {
+		return false
+	}
+
+	// restore ascending order and position on the greatest key
+	for i, j := 0, len(c.page)-1; i < j; i, j = i+1, j-1 {
+		c.page[i], c.page[j] = c.page[j], c.page[i]
+	}
+
+	c.idx = len(c.page) - 1
+	return true
+}
+
+func (c *Cursor) fetch(from, to string, order clientv3.SortOrder) bool {
+	opts := []clientv3.OpOption{
+		clientv3.WithRange(to),
+		clientv3.WithLimit(c.pageSize),
+		clientv3.WithSort(clientv3.SortByKey, order),
+	}
+
+	if c.rev > 0 {
+		opts = append(opts, clientv3.WithRev(c.rev))
+	}
+
+	if c.keysOnly {
+		opts = append(opts, clientv3.WithKeysOnly())
+	}
+
+	resp, err := c.view.kv.Get(c.ctx, from, opts...)
+	if err != nil {
+		c.err = err
+		c.page, c.positioned, c.exhausted = nil, false, true
+		return false
+	}
+
+	if c.rev == 0 {
+		c.rev = resp.Header.Revision
+	}
+
+	c.page, c.idx = resp.Kvs, 0
+	if len(c.page) == 0 {
+		c.positioned, c.exhausted = false, true
+		return false
+	}
+
+	c.positioned, c.exhausted = true, false
+	return true
+}
+
+func (c *Cursor) Item() kv.Item {
+	if !c.positioned {
+		return kv.Item{}
+	}
+
+	pair := c.page[c.idx]
+	return kv.Item{
+		K:       bytes.TrimPrefix(pair.Key, append(c.view.prefix, '/')),
+		V:       pair.Value,
+		Version: pair.ModRevision,
+	}
+}
+
+func (c *Cursor) Err() error { return c.err }
+
+// Close is a noop for etcd as cursors hold no resources between requests.
+func (c *Cursor) Close() error { return nil }
@@ -3,6 +3,8 @@ package etcd
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/georgemac/dokvs/pkg/kv"
@@ -11,31 +13,100 @@ import (
 
 var _ kv.Store = (*KV)(nil)
 
+// ErrTxnTooLarge is returned when an operation which must be applied in a
+// single transaction exceeds the configured max txn ops.
+var ErrTxnTooLarge = errors.New("transaction too large")
+
+// ErrInvalidKeyspace is returned when a keyspace name contains a '/',
+// which separates the name of a keyspace from the keys within it.
+var ErrInvalidKeyspace = errors.New("keyspace name contains '/'")
+
+const (
+	// defaultCursorPageSize is the default number of items fetched
+	// per request when iterating using a Cursor.
+	defaultCursorPageSize = 100
+	// defaultMaxTxnOps is the default of the etcd server's --max-txn-ops.
+	defaultMaxTxnOps = 128
+	// defaultMaxRequestBytes is the default of the etcd server's --max-request-bytes.
+	defaultMaxRequestBytes = 1.5 * 1024 * 1024
+)
+
 type KV struct {
-	kv clientv3.KV
+	kv     clientv3.KV
+	config config
 }
 
-func New(kv clientv3.KV) *KV {
-	return &KV{kv: kv}
+// config contains the tunable parameters of the etcd backend
+// which are shared by views and updates.
+type config struct {
+	cursorPageSize  int64
+	maxTxnOps       int
+	maxRequestBytes int
+}
+
+func New(kv clientv3.KV, opts ...func(*KV)) *KV {
+	k := &KV{kv: kv, config: config{
+		cursorPageSize:  defaultCursorPageSize,
+		maxTxnOps:       defaultMaxTxnOps,
+		maxRequestBytes: defaultMaxRequestBytes,
+	}}
+	for _, opt := range opts {
+		opt(k)
+	}
+
+	return k
+}
+
+// WithCursorPageSize configures the number of items fetched per request
+// by a Cursor as it iterates.
+func WithCursorPageSize(n int64) func(*KV) {
+	return func(k *KV) {
+		k.config.cursorPageSize = n
+	}
+}
+
+// WithMaxTxnOps configures the maximum number of operations in each
+// transaction used by Get, PutMany, DeleteMany and RenameKeyspace. It should
+// not exceed the --max-txn-ops configured on the etcd server.
+func WithMaxTxnOps(n int) func(*KV) {
+	return func(k *KV) {
+		k.config.maxTxnOps = n
+	}
+}
+
+// WithMaxRequestBytes configures the approximate maximum size of each
+// transaction used by Get, PutMany, DeleteMany and RenameKeyspace. It should not exceed
+// the --max-request-bytes configured on the etcd server.
+func WithMaxRequestBytes(n int) func(*KV) {
+	return func(k *KV) {
+		k.config.maxRequestBytes = n
+	}
 }
 
 func (kv KV) View(fn func(kv.View) error) error {
-	return fn(View{kv: kv.kv})
+	return fn(View{kv: kv.kv, config: kv.config})
 }
 
 type View struct {
-	kv clientv3.KV
+	kv     clientv3.KV
+	config config
 }
 
 func (v View) Keyspace(key []byte) (_ kv.KeyspaceView, err error) {
+	if err := validKeyspace(key); err != nil {
+		return nil, err
+	}
+
 	return KeyspaceView{
 		kv:     v.kv,
+		config: v.config,
 		prefix: key,
 	}, nil
 }
 
 type KeyspaceView struct {
 	kv     clientv3.KV
+	config config
 	prefix []byte
 }
 
@@ -43,20 +114,20 @@ func (k KeyspaceView) key(v []byte) string {
 	return strings.Join([]string{string(k.prefix), string(v)}, "/")
 }
 
+// Get reads the keys in as few transactions as the configured operation and
+// request size limits allow. Every transaction after the first reads at the
+// revision of the first, so the items are read from a consistent snapshot.
 func (k KeyspaceView) Get(ctx context.Context, opts kv.GetOptions) (items []kv.Item, err error) {
-	getOps := make([]clientv3.Op, len(opts.Keys))
+	sizes := make([]int, len(opts.Keys))
 	for i := range opts.Keys {
-		getOps[i] = clientv3.OpGet(k.key(opts.Keys[i]))
+		sizes[i] = len(k.prefix) + 1 + len(opts.Keys[i]) + txnOpOverhead
 	}
 
-	resp, err := k.kv.Txn(ctx).
-		Then(getOps...).
-		Commit()
-	if err != nil {
-		return nil, err
-	}
+	var (
+		berr *kv.BatchError
+		rev  int64
+	)
 
-	var berr *kv.BatchError
 	appendError := func(i int, err error) {
 		if berr == nil {
 			berr = &kv.BatchError{
@@ -67,22 +138,46 @@ func (k KeyspaceView) Get(ctx context.Context, opts kv.GetOptions) (items []kv.I
 		berr.Errors[i] = err
 	}
 
-	items = make([]kv.Item, len(resp.Responses))
-	for i, op := range resp.Responses {
-		items[i].K = opts.Keys[i]
+	items = make([]kv.Item, len(opts.Keys))
+	if err := k.config.batch(sizes, func(start, end int) error {
+		getOps := make([]clientv3.Op, 0, end-start)
+		for i := start; i < end; i++ {
+			getOps = append(getOps, clientv3.OpGet(k.key(opts.Keys[i]), clientv3.WithRev(rev)))
+		}
 
-		rng := op.GetResponseRange()
-		if rng == nil {
-			appendError(i, kv.ErrKeyNotFound)
-			continue
+		resp, err := k.kv.Txn(ctx).
+			Then(getOps...).
+			Commit()
+		if err != nil {
+			return err
 		}
 
-		if len(rng.Kvs) < 1 {
-			appendError(i, kv.ErrKeyNotFound)
-			continue
+		if rev == 0 {
+			rev = resp.Header.Revision
 		}
 
-		items[i].V = rng.Kvs[0].Value
+		for j, op := range resp.Responses {
+			i := start + j
+			items[i].K = opts.Keys[i]
+
+			rng := op.GetResponseRange()
+			if rng == nil {
+				appendError(i, kv.ErrKeyNotFound)
+				continue
+			}
+
+			if len(rng.Kvs) < 1 {
+				appendError(i, kv.ErrKeyNotFound)
+				continue
+			}
+
+			items[i].V = rng.Kvs[0].Value
+			items[i].Version = rng.Kvs[0].ModRevision
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
 	}
 
 	if berr != nil {
@@ -105,31 +200,69 @@ func (k KeyspaceView) prefixKey(dst *[]byte, src []byte) {
 	}
 }
 
+// bounds returns the inclusive start and exclusive end of the range
+// as keys prefixed by the keyspace.
+func (k KeyspaceView) bounds(rng kv.RangeOptions) (start, end string) {
+	rngStart, rngEnd := rng.Bounds()
+
+	var s []byte
+	k.prefixKey(&s, rngStart)
+
+	var e []byte
+	k.prefixKey(&e, rngEnd)
+
+	if rngEnd == nil {
+		return string(s), clientv3.GetPrefixRangeEnd(string(e))
+	}
+
+	return string(s), string(e)
+}
+
+// keyRange returns the key and options which restrict an etcd request to the
+// bounds of the range. Prefix-only ranges are requested natively using WithPrefix.
+func (k KeyspaceView) keyRange(rng kv.RangeOptions) (key string, opts []clientv3.OpOption) {
+	if rng.Prefix != nil && rng.Start == nil && rng.End == nil {
+		var p []byte
+		k.prefixKey(&p, rng.Prefix)
+
+		return string(p), []clientv3.OpOption{clientv3.WithPrefix()}
+	}
+
+	key, end := k.bounds(rng)
+
+	return key, []clientv3.OpOption{clientv3.WithRange(end)}
+}
+
+// rangeOp returns the key and options of an etcd request for the range.
+func (k KeyspaceView) rangeOp(rng kv.RangeOptions) (key string, opts []clientv3.OpOption) {
+	key, opts = k.keyRange(rng)
+
+	opts = append(opts, clientv3.WithLimit(int64(rng.Limit)))
+
+	if rng.Reverse {
+		opts = append(opts, clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend))
+	}
+
+	if rng.KeysOnly {
+		opts = append(opts, clientv3.WithKeysOnly())
+	}
+
+	if rng.CountOnly {
+		opts = append(opts, clientv3.WithCountOnly())
+	}
+
+	return
+}
+
 func (k KeyspaceView) Range(ctx context.Context, opts ...kv.RangeOption) (items []kv.Item, err error) {
 	var rng kv.RangeOptions
 	for _, opt := range opts {
 		opt(&rng)
 	}
 
-	var start []byte
-	k.prefixKey(&start, rng.Start)
-
-	var end []byte
-	k.prefixKey(&end, rng.End)
-
-	var rngEnd string
-	if rng.End == nil {
-		rngEnd = clientv3.GetPrefixRangeEnd(string(end))
-	} else {
-		rngEnd = string(end)
-	}
+	key, rngOpts := k.rangeOp(rng)
 
-	resp, err := k.kv.Get(
-		ctx,
-		string(start),
-		clientv3.WithRange(rngEnd),
-		clientv3.WithLimit(int64(rng.Limit)),
-	)
+	resp, err := k.kv.Get(ctx, key, rngOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -138,27 +271,208 @@ func (k KeyspaceView) Range(ctx context.Context, opts ...kv.RangeOption) (items
 	for i := range resp.Kvs {
 		items[i].K = bytes.TrimPrefix(resp.Kvs[i].Key, append(k.prefix, '/'))
 		items[i].V = resp.Kvs[i].Value
+		items[i].Version = resp.Kvs[i].ModRevision
 	}
 
 	return
 }
 
+func (k KeyspaceView) Count(ctx context.Context, opts ...kv.RangeOption) (int, error) {
+	rng := kv.RangeOptions{}
+	for _, opt := range opts {
+		opt(&rng)
+	}
+
+	rng.CountOnly = true
+
+	key, rngOpts := k.rangeOp(rng)
+
+	resp, err := k.kv.Get(ctx, key, rngOpts...)
+	if err != nil {
+		return 0, err
+	}
+
+	count := int(resp.Count)
+	if rng.Limit > 0 && count > rng.Limit {
+		count = rng.Limit
+	}
+
+	return count, nil
+}
+
 func (kv KV) Update(fn func(kv.Update) error) error {
-	return fn(Update{kv: kv.kv})
+	return fn(Update{kv: kv.kv, config: kv.config})
 }
 
 type Update struct {
-	kv clientv3.KV
+	kv     clientv3.KV
+	config config
 }
 
-// CreateKeyspace is a noop for etcd.
+// CreateKeyspace is a noop for etcd, other than validating the name of the keyspace.
 func (u Update) CreateKeyspace(key []byte) error {
+	return validKeyspace(key)
+}
+
+// DeleteKeyspace deletes every key within the keyspace using a single prefix delete.
+func (u Update) DeleteKeyspace(ctx context.Context, key []byte) error {
+	if err := validKeyspace(key); err != nil {
+		return err
+	}
+
+	_, err := u.kv.Delete(ctx, keyspacePrefix(key), clientv3.WithPrefix())
+	return err
+}
+
+// TruncateKeyspace is equivalent to DeleteKeyspace for etcd.
+func (u Update) TruncateKeyspace(ctx context.Context, key []byte) error {
+	return u.DeleteKeyspace(ctx, key)
+}
+
+// RenameKeyspace deletes every key within the keyspace and puts it under the
+// new keyspace. The keyspace is read in pages using a Cursor. Keyspaces which
+// fit within the configured max txn ops and request bytes are renamed in a
+// single transaction, which only commits when the source keyspace is
+// unmodified since it was read and the destination is empty.
+//
+// Larger keyspaces are copied to the destination in batches before the source
+// is deleted, so readers may observe the keys under both names until the rename
+// completes. The source is only deleted when it is unmodified since it was read,
+// otherwise the copy is removed and kv.ErrConflict is returned.
+func (u Update) RenameKeyspace(ctx context.Context, from, to []byte) error {
+	if err := validKeyspace(from); err != nil {
+		return err
+	}
+
+	if err := validKeyspace(to); err != nil {
+		return err
+	}
+
+	var (
+		fromPrefix, toPrefix = keyspacePrefix(from), keyspacePrefix(to)
+		source               = KeyspaceView{kv: u.kv, config: u.config, prefix: from}.cursor(ctx, kv.RangeOptions{})
+		empty                = clientv3.Compare(clientv3.CreateRevision(toPrefix).WithPrefix(), "=", 0)
+		deleteFrom           = clientv3.OpDelete(fromPrefix, clientv3.WithPrefix())
+		deleteSize           = len(fromPrefix) + txnOpOverhead
+		puts                 []clientv3.Op
+		size                 int
+		copied               bool
+	)
+
+	// flush commits the pending puts, the first of which requires the destination is empty
+	flush := func() error {
+		txn := u.kv.Txn(ctx)
+		if !copied {
+			txn = txn.If(empty)
+		}
+
+		resp, err := txn.Then(puts...).Commit()
+		if err != nil {
+			return u.abortRename(ctx, toPrefix, copied, err)
+		}
+
+		if !resp.Succeeded {
+			return fmt.Errorf("keyspace %q: %w", to, kv.ErrKeyspaceExists)
+		}
+
+		puts, size, copied = puts[:0], 0, true
+		return nil
+	}
+
+	for source.Next() {
+		item := source.Item()
+		opSize := len(toPrefix) + len(item.K) + len(item.V) + txnOpOverhead
+
+		if len(puts) > 0 && (len(puts) >= u.config.maxTxnOps || size+opSize > u.config.maxRequestBytes) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		puts = append(puts, clientv3.OpPut(toPrefix+string(item.K), string(item.V)))
+		size += opSize
+	}
+
+	if err := source.Err(); err != nil {
+		return u.abortRename(ctx, toPrefix, copied, err)
+	}
+
+	unmodified := clientv3.Compare(clientv3.ModRevision(fromPrefix).WithPrefix(), "<", source.rev+1)
+
+	if !copied && len(puts)+1 <= u.config.maxTxnOps && size+deleteSize <= u.config.maxRequestBytes {
+		txn, err := u.kv.Txn(ctx).
+			If(unmodified, empty).
+			Then(append([]clientv3.Op{deleteFrom}, puts...)...).
+			Else(clientv3.OpGet(toPrefix, clientv3.WithPrefix(), clientv3.WithCountOnly())).
+			Commit()
+		if err != nil {
+			return err
+		}
+
+		if !txn.Succeeded {
+			if txn.Responses[0].GetResponseRange().Count > 0 {
+				return fmt.Errorf("keyspace %q: %w", to, kv.ErrKeyspaceExists)
+			}
+
+			return fmt.Errorf("renaming keyspace %q: %w", from, kv.ErrConflict)
+		}
+
+		return nil
+	}
+
+	if len(puts) > 0 {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+
+	txn, err := u.kv.Txn(ctx).If(unmodified).Then(deleteFrom).Commit()
+	if err != nil {
+		return u.abortRename(ctx, toPrefix, true, err)
+	}
+
+	if !txn.Succeeded {
+		return u.abortRename(ctx, toPrefix, true, fmt.Errorf("renaming keyspace %q: %w", from, kv.ErrConflict))
+	}
+
+	return nil
+}
+
+// abortRename removes the partial copy of a batched rename, when any
+// batch was copied, before returning err.
+func (u Update) abortRename(ctx context.Context, toPrefix string, copied bool, err error) error {
+	if copied {
+		// the copy is removed on a best effort basis as the rename has already failed
+		_, _ = u.kv.Delete(ctx, toPrefix, clientv3.WithPrefix())
+	}
+
+	return err
+}
+
+// keyspacePrefix returns the prefix of every key within the keyspace.
+func keyspacePrefix(key []byte) string {
+	return string(key) + "/"
+}
+
+// validKeyspace returns ErrInvalidKeyspace when the name of a keyspace
+// contains a '/', as the prefix of its keys would then also prefix the
+// keys of other keyspaces, e.g. those of "a/b" would be within "a".
+func validKeyspace(key []byte) error {
+	if bytes.IndexByte(key, '/') >= 0 {
+		return fmt.Errorf("keyspace %q: %w", key, ErrInvalidKeyspace)
+	}
+
 	return nil
 }
 
 func (u Update) Keyspace(key []byte) (_ kv.KeyspaceUpdate, err error) {
+	if err := validKeyspace(key); err != nil {
+		return nil, err
+	}
+
 	return KeyspaceUpdate{
 		kv:     u.kv,
+		config: u.config,
 		prefix: key,
 	}, nil
 }
@@ -173,12 +487,154 @@ func (u KeyspaceUpdate) Range(ctx context.Context, opts ...kv.RangeOption) ([]kv
 	return KeyspaceView(u).Range(ctx, opts...)
 }
 
-func (u KeyspaceUpdate) Put(ctx context.Context, k, v []byte) error {
-	_, err := u.kv.Put(ctx, string(k), string(v))
-	return err
+func (u KeyspaceUpdate) Count(ctx context.Context, opts ...kv.RangeOption) (int, error) {
+	return KeyspaceView(u).Count(ctx, opts...)
+}
+
+// Put puts v at k in the keyspace.
+// Conditional puts are performed in a transaction which compares the
+// revisions of the key. The ModRevision of a key is used as the version
+// of items in etcd and a CreateRevision of zero denotes an absent key.
+func (u KeyspaceUpdate) Put(ctx context.Context, k, v []byte, opts ...kv.PutOption) error {
+	var put kv.PutOptions
+	for _, opt := range opts {
+		opt(&put)
+	}
+
+	key := KeyspaceView(u).key(k)
+
+	if !put.Conditional() {
+		_, err := u.kv.Put(ctx, key, string(v))
+		return err
+	}
+
+	resp, err := u.kv.Txn(ctx).
+		If(putCompares(key, put)...).
+		Then(clientv3.OpPut(key, string(v))).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return err
+	}
+
+	if resp.Succeeded {
+		return nil
+	}
+
+	// determine which condition failed from the current state of the key
+	var version int64
+	if kvs := resp.Responses[0].GetResponseRange().Kvs; len(kvs) > 0 {
+		version = kvs[0].ModRevision
+	}
+
+	if err := put.Check(version); err != nil {
+		return err
+	}
+
+	return kv.ErrConflict
+}
+
+// putCompares returns the transaction comparisons which enforce the conditions of put.
+func putCompares(key string, put kv.PutOptions) (cmps []clientv3.Cmp) {
+	if put.IfVersion != nil {
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", *put.IfVersion))
+	}
+
+	if put.IfAbsent {
+		cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+	}
+
+	if put.IfPresent {
+		cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), ">", 0))
+	}
+
+	return
 }
 
 func (u KeyspaceUpdate) Delete(ctx context.Context, k []byte) error {
-	_, err := u.kv.Delete(ctx, string(k))
+	_, err := u.kv.Delete(ctx, KeyspaceView(u).key(k))
 	return err
 }
+
+// DeleteRange deletes every key within the bounds of the range using
+// a single native range delete and returns the number of keys deleted.
+func (u KeyspaceUpdate) DeleteRange(ctx context.Context, opts ...kv.RangeOption) (int, error) {
+	var rng kv.RangeOptions
+	for _, opt := range opts {
+		opt(&rng)
+	}
+
+	key, rngOpts := KeyspaceView(u).keyRange(rng)
+
+	resp, err := u.kv.Delete(ctx, key, rngOpts...)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(resp.Deleted), nil
+}
+
+// txnOpOverhead is a conservative estimate of the encoded size of
+// an operation within a transaction, excluding its key and value.
+const txnOpOverhead = 32
+
+// PutMany puts the items in as few transactions as the configured
+// operation and request size limits allow.
+func (u KeyspaceUpdate) PutMany(ctx context.Context, items ...kv.Item) error {
+	ops := make([]clientv3.Op, len(items))
+	sizes := make([]int, len(items))
+	for i, item := range items {
+		key := KeyspaceView(u).key(item.K)
+		ops[i] = clientv3.OpPut(key, string(item.V))
+		sizes[i] = len(key) + len(item.V) + txnOpOverhead
+	}
+
+	return u.commitBatched(ctx, ops, sizes)
+}
+
+// DeleteMany deletes the keys in as few transactions as the configured
+// operation and request size limits allow.
+func (u KeyspaceUpdate) DeleteMany(ctx context.Context, keys ...[]byte) error {
+	ops := make([]clientv3.Op, len(keys))
+	sizes := make([]int, len(keys))
+	for i, k := range keys {
+		key := KeyspaceView(u).key(k)
+		ops[i] = clientv3.OpDelete(key)
+		sizes[i] = len(key) + txnOpOverhead
+	}
+
+	return u.commitBatched(ctx, ops, sizes)
+}
+
+// commitBatched commits ops in sequential transactions each containing
+// at most maxTxnOps operations and approximately maxRequestBytes bytes.
+func (u KeyspaceUpdate) commitBatched(ctx context.Context, ops []clientv3.Op, sizes []int) error {
+	return u.config.batch(sizes, func(start, end int) error {
+		_, err := u.kv.Txn(ctx).Then(ops[start:end]...).Commit()
+		return err
+	})
+}
+
+// batch calls fn with the bounds of consecutive, non-empty runs of operations
+// of the provided sizes, each containing at most maxTxnOps operations and
+// approximately maxRequestBytes bytes.
+func (c config) batch(sizes []int, fn func(start, end int) error) error {
+	var start, size int
+	for i := range sizes {
+		if i > start && (i-start >= c.maxTxnOps || size+sizes[i] > c.maxRequestBytes) {
+			if err := fn(start, i); err != nil {
+				return err
+			}
+
+			start, size = i, 0
+		}
+
+		size += sizes[i]
+	}
+
+	if start == len(sizes) {
+		return nil
+	}
+
+	return fn(start, len(sizes))
+}
@@ -1,13 +1,17 @@
 package etcd
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"os"
 	"strings"
 	"testing"
 
 	"github.com/georgemac/dokvs/pkg/kv"
 	kvtesting "github.com/georgemac/dokvs/pkg/kv/testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/tests/v3/integration"
 )
@@ -25,10 +29,100 @@ func TestEtcd_KVStore_TestingHarness(t *testing.T) {
 			}
 		}
 
-		return New(db)
+		// small page and transaction sizes ensure cursors must page
+		// between every item and batches are split across transactions
+		return New(db, WithCursorPageSize(1), WithMaxTxnOps(2))
 	})
 }
 
+func TestEtcd_InvalidKeyspace(t *testing.T) {
+	db, cleanup := newETCD(t)
+	t.Cleanup(cleanup)
+
+	var (
+		ctx   = context.Background()
+		store = New(db)
+	)
+
+	// the keys of "books/x" would otherwise be within the keyspace "books"
+	assert.ErrorIs(t, store.View(func(tx kv.View) error {
+		_, err := tx.Keyspace([]byte("books/x"))
+		return err
+	}), ErrInvalidKeyspace)
+
+	assert.ErrorIs(t, store.Update(func(tx kv.Update) error {
+		_, err := tx.Keyspace([]byte("books/x"))
+		return err
+	}), ErrInvalidKeyspace)
+
+	assert.ErrorIs(t, store.Update(func(tx kv.Update) error {
+		return tx.RenameKeyspace(ctx, []byte("books"), []byte("books/x"))
+	}), ErrInvalidKeyspace)
+
+	assert.ErrorIs(t, store.Update(func(tx kv.Update) error {
+		return tx.DeleteKeyspace(ctx, []byte("books/x"))
+	}), ErrInvalidKeyspace)
+}
+
+func TestEtcd_RenameKeyspace_RequestBytes(t *testing.T) {
+	integration.BeforeTest(t)
+
+	const maxRequestBytes = 64 * 1024
+
+	cluster := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1, MaxRequestBytes: maxRequestBytes})
+	t.Cleanup(func() { cluster.Terminate(t) })
+
+	client, err := cluster.ClusterClient()
+	require.NoError(t, err)
+
+	var (
+		ctx   = context.Background()
+		store = New(client.KV, WithMaxRequestBytes(maxRequestBytes), WithCursorPageSize(7))
+		value = bytes.Repeat([]byte("v"), 4*1024)
+		items = make([]kv.Item, 60)
+	)
+
+	for i := range items {
+		items[i] = kv.Item{K: []byte(fmt.Sprintf("%02d", i)), V: value}
+	}
+
+	require.NoError(t, store.Update(func(tx kv.Update) error {
+		ks, err := tx.Keyspace([]byte("from"))
+		require.NoError(t, err)
+
+		return ks.PutMany(ctx, items...)
+	}))
+
+	// the keyspace exceeds the request size permitted by the server
+	// so is read in pages and copied in several transactions
+	require.NoError(t, store.Update(func(tx kv.Update) error {
+		return tx.RenameKeyspace(ctx, []byte("from"), []byte("to"))
+	}))
+
+	require.NoError(t, store.View(func(tx kv.View) error {
+		from, err := tx.Keyspace([]byte("from"))
+		require.NoError(t, err)
+
+		n, err := from.Count(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, n)
+
+		to, err := tx.Keyspace([]byte("to"))
+		require.NoError(t, err)
+
+		found, err := to.Range(ctx)
+		require.NoError(t, err)
+		require.Len(t, found, len(items))
+
+		for i := range found {
+			assert.Equal(t, items[i].K, found[i].K)
+			assert.Equal(t, items[i].V, found[i].V)
+		}
+
+		return nil
+	}))
+}
+
 func newETCD(t *testing.T) (clientv3.KV, func()) {
 	t.Helper()
 
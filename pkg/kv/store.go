@@ -1,6 +1,7 @@
 package kv
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -10,9 +11,19 @@ import (
 // is not found in the store.
 var ErrKeyspaceNotFound = errors.New("keyspace not found")
 
+// ErrKeyspaceExists is returned when creating a keyspace which already exists.
+var ErrKeyspaceExists = errors.New("keyspace already exists")
+
 // ErrKeyNotFound is returned when a key is not found.
 var ErrKeyNotFound = errors.New("key not found")
 
+// ErrKeyExists is returned when a key is present but is required to be absent.
+var ErrKeyExists = errors.New("key already exists")
+
+// ErrConflict is returned when a conditional put is rejected because
+// the version of the existing item does not match the expected version.
+var ErrConflict = errors.New("version conflict")
+
 // BatchError is a struct containing a slice of errors which also
 // implements the error interface.
 // It is returned when any item in a batch requested via Get
@@ -31,6 +42,9 @@ func (b BatchError) Error() string {
 // Item is a single entry in a Key/Value keyspace in a Key/Value store.
 type Item struct {
 	K, V []byte
+	// Version identifies the last modification of the item.
+	// It increases each time the item is put and is zero when the item is absent.
+	Version int64
 }
 
 // Store represents a Key/Value store which support both read-only (View)
@@ -91,6 +105,54 @@ func Limit(n int) RangeOption {
 	}
 }
 
+// Prefix restricts a Range call to keys which begin with prefix.
+// See RangeOptions{}.
+func Prefix(prefix []byte) RangeOption {
+	return func(o *RangeOptions) {
+		o.Prefix = prefix
+	}
+}
+
+// Reverse configures a Range call to return items in descending key order.
+// See RangeOptions{}.
+func Reverse() RangeOption {
+	return func(o *RangeOptions) {
+		o.Reverse = true
+	}
+}
+
+// KeysOnly configures a Range call to omit the values of the items returned.
+// See RangeOptions{}.
+func KeysOnly() RangeOption {
+	return func(o *RangeOptions) {
+		o.KeysOnly = true
+	}
+}
+
+// CountOnly configures a range to count the items within it rather than read them.
+// It is implied by KeyspaceView.Count, and a Range call configured with CountOnly returns no items.
+// See RangeOptions{}.
+func CountOnly() RangeOption {
+	return func(o *RangeOptions) {
+		o.CountOnly = true
+	}
+}
+
+// PrefixEnd returns the smallest key which is greater than every key
+// beginning with prefix. It returns nil when no such key exists (i.e. when
+// the prefix is empty or consists entirely of 0xFF bytes).
+func PrefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xFF {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	return nil
+}
+
 // RangeOptions is used when requesting a Range of items from a key/value store.
 //
 // It us used in a call to KeyspaceView.Range to fetch a sequence of items.
@@ -106,7 +168,61 @@ type RangeOptions struct {
 	// If End != nil, this refers to items with key < end.
 	End []byte
 	// Limit is the maximum number of items to return.
+	// If Limit < 1, all items in the range are returned.
 	Limit int
+	// Prefix restricts the range to items with keys beginning with prefix.
+	// It is combined with Start and End such that the narrowest bounds apply.
+	Prefix []byte
+	// Reverse returns items in descending key order beginning from End.
+	Reverse bool
+	// KeysOnly omits the values of the items returned.
+	KeysOnly bool
+	// CountOnly counts the items in range rather than return them.
+	CountOnly bool
+}
+
+// Bounds returns the inclusive start and exclusive end of the range
+// after narrowing Start and End to Prefix.
+// A nil start or end refers to the beginning or end of the keyspace respectively.
+func (o RangeOptions) Bounds() (start, end []byte) {
+	start, end = o.Start, o.End
+	if o.Prefix == nil {
+		return
+	}
+
+	if start == nil || bytes.Compare(start, o.Prefix) < 0 {
+		start = o.Prefix
+	}
+
+	if prefixEnd := PrefixEnd(o.Prefix); prefixEnd != nil &&
+		(end == nil || bytes.Compare(prefixEnd, end) < 0) {
+		end = prefixEnd
+	}
+
+	return
+}
+
+// Cursor is an iterator over a range of items in a keyspace.
+// Unlike Range, a Cursor does not materialize the entire range and
+// so can be used to walk arbitrarily large ranges in constant memory.
+//
+// A new Cursor is unpositioned: a call to Next positions it at the first
+// item in its range and a call to Prev at the last. Once Next or Prev
+// returns false the cursor is exhausted until repositioned using Seek.
+type Cursor interface {
+	// Seek positions the cursor at the first item in range with a key >= key.
+	// It reports whether such an item exists.
+	Seek(key []byte) bool
+	// Next moves the cursor to the following item and reports whether it exists.
+	Next() bool
+	// Prev moves the cursor to the preceding item and reports whether it exists.
+	Prev() bool
+	// Item returns the item at the current position of the cursor.
+	Item() Item
+	// Err returns the first error encountered while iterating, if any.
+	Err() error
+	// Close releases any resources held by the cursor.
+	Close() error
 }
 
 // KeyspaceView is a read-only client for accessing ranges of a single keyspace
@@ -114,16 +230,97 @@ type RangeOptions struct {
 type KeyspaceView interface {
 	Get(context.Context, GetOptions) ([]Item, error)
 	Range(context.Context, ...RangeOption) ([]Item, error)
+	// Count returns the number of items in the range.
+	Count(context.Context, ...RangeOption) (int, error)
+	// Cursor returns a Cursor bounded by the Start, End and Prefix of the provided options.
+	// Limit, Reverse and CountOnly are not applied to cursors.
+	Cursor(context.Context, ...RangeOption) (Cursor, error)
 }
 
 // Update is a read-write transaction of a KV store.
 // It supports creating new keyspaces as well as obtaining
 // mutable KeyspaceUpdate; used to perform keyspace updates.
 type Update interface {
+	// CreateKeyspace creates the named keyspace. It returns ErrKeyspaceExists
+	// when the keyspace already exists on backends which track keyspaces.
 	CreateKeyspace([]byte) error
+	// DeleteKeyspace deletes the named keyspace along with every item within it.
+	// It returns ErrKeyspaceNotFound when the keyspace does not exist on
+	// backends which track keyspaces.
+	DeleteKeyspace(context.Context, []byte) error
+	// TruncateKeyspace deletes every item within the named keyspace and retains the keyspace.
+	TruncateKeyspace(context.Context, []byte) error
+	// RenameKeyspace moves every item from one keyspace to another, which
+	// must not already exist or, on backends which do not track keyspaces,
+	// must be empty. It returns ErrKeyspaceExists otherwise.
+	RenameKeyspace(_ context.Context, from, to []byte) error
 	Keyspace([]byte) (KeyspaceUpdate, error)
 }
 
+// PutOption is a function which configures a PutOptions
+type PutOption func(*PutOptions)
+
+// IfVersion configures a Put call to only succeed when the current
+// version of the item is equal to version.
+// See PutOptions{}.
+func IfVersion(version int64) PutOption {
+	return func(o *PutOptions) {
+		o.IfVersion = &version
+	}
+}
+
+// IfAbsent configures a Put call to only succeed when the key is not present.
+// See PutOptions{}.
+func IfAbsent() PutOption {
+	return func(o *PutOptions) {
+		o.IfAbsent = true
+	}
+}
+
+// IfPresent configures a Put call to only succeed when the key is present.
+// See PutOptions{}.
+func IfPresent() PutOption {
+	return func(o *PutOptions) {
+		o.IfPresent = true
+	}
+}
+
+// PutOptions is used to make a call to KeyspaceUpdate.Put conditional.
+// The zero-value of put options represents an unconditional put.
+type PutOptions struct {
+	// IfVersion when not nil requires the version of the existing item to equal *IfVersion.
+	// A version of zero requires that the item is absent.
+	// When the versions differ Put returns ErrConflict.
+	IfVersion *int64
+	// IfAbsent requires that the key is not present.
+	// When the key is present Put returns ErrKeyExists.
+	IfAbsent bool
+	// IfPresent requires that the key is present.
+	// When the key is absent Put returns ErrKeyNotFound.
+	IfPresent bool
+}
+
+// Check returns the error a conditional Put must return given the
+// version of the existing item, where zero denotes an absent item.
+// It returns nil when every condition is satisfied.
+func (o PutOptions) Check(version int64) error {
+	switch {
+	case o.IfAbsent && version != 0:
+		return ErrKeyExists
+	case o.IfPresent && version == 0:
+		return ErrKeyNotFound
+	case o.IfVersion != nil && *o.IfVersion != version:
+		return ErrConflict
+	}
+
+	return nil
+}
+
+// Conditional returns true when any condition is configured.
+func (o PutOptions) Conditional() bool {
+	return o.IfVersion != nil || o.IfAbsent || o.IfPresent
+}
+
 // KeyspaceUpdate is a read-write interface across a particular keyspace, which can:
 // Range across the keyspace.
 // Put into the keyspace.
@@ -131,6 +328,17 @@ type Update interface {
 type KeyspaceUpdate interface {
 	KeyspaceView
 
-	Put(_ context.Context, k, v []byte) error
+	Put(_ context.Context, k, v []byte, opts ...PutOption) error
 	Delete(_ context.Context, k []byte) error
+	// PutMany puts each of the items into the keyspace.
+	// Backends may split large batches into multiple requests, in which case
+	// a failure can leave a prefix of the batch applied.
+	PutMany(_ context.Context, items ...Item) error
+	// DeleteMany deletes each of the keys from the keyspace.
+	// Like PutMany, large batches may be split into multiple requests.
+	DeleteMany(_ context.Context, keys ...[]byte) error
+	// DeleteRange deletes every key within the Start, End and Prefix bounds
+	// of the range and returns the number of keys deleted.
+	// Limit, Reverse, KeysOnly and CountOnly are not applied.
+	DeleteRange(context.Context, ...RangeOption) (int, error)
 }
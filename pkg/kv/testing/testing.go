@@ -2,6 +2,7 @@ package testing
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/georgemac/dokvs/pkg/kv"
@@ -52,7 +53,7 @@ func TestHarness(t *testing.T, fn StoreFactory) {
 						require.NoError(t, err)
 
 						expected := []kv.Item{{K: []byte("a"), V: []byte("value_one")}}
-						assert.Equal(t, expected, items)
+						assert.Equal(t, expected, withoutVersions(items))
 					})
 
 					t.Run(`Get(Key("d")) returns not found`, func(t *testing.T) {
@@ -74,7 +75,7 @@ func TestHarness(t *testing.T, fn StoreFactory) {
 							{K: []byte("a"), V: []byte("value_one")},
 							{K: []byte("b"), V: []byte("value_two")},
 						}
-						assert.Equal(t, expected, items)
+						assert.Equal(t, expected, withoutVersions(items))
 					})
 
 					t.Run(`Get(Batch("a", "d")) returns ["a", <not found>]`, func(t *testing.T) {
@@ -84,7 +85,7 @@ func TestHarness(t *testing.T, fn StoreFactory) {
 							{K: []byte("a"), V: []byte("value_one")},
 							{K: []byte("d")}, // item not present
 						}
-						assert.Equal(t, expected, items)
+						assert.Equal(t, expected, withoutVersions(items))
 						require.Equal(
 							t,
 							&kv.BatchError{
@@ -97,6 +98,26 @@ func TestHarness(t *testing.T, fn StoreFactory) {
 						)
 					})
 
+					t.Run(`Get(Batch("c", "d", "b", "e", "a")) returns items in the order requested`, func(t *testing.T) {
+						items, err := keyspace.Get(ctx, kv.Batch([]byte("c"), []byte("d"), []byte("b"), []byte("e"), []byte("a")))
+
+						expected := []kv.Item{
+							{K: []byte("c"), V: []byte("value_three")},
+							{K: []byte("d")}, // item not present
+							{K: []byte("b"), V: []byte("value_two")},
+							{K: []byte("e")}, // item not present
+							{K: []byte("a"), V: []byte("value_one")},
+						}
+						assert.Equal(t, expected, withoutVersions(items))
+						require.Equal(
+							t,
+							&kv.BatchError{
+								Errors: []error{nil, kv.ErrKeyNotFound, nil, kv.ErrKeyNotFound, nil},
+							},
+							err,
+						)
+					})
+
 					t.Run(`Range(["a", "c")) returns ["a", "b"]`, func(t *testing.T) {
 						items, err := keyspace.Range(
 							ctx,
@@ -109,7 +130,7 @@ func TestHarness(t *testing.T, fn StoreFactory) {
 							{K: []byte("a"), V: []byte("value_one")},
 							{K: []byte("b"), V: []byte("value_two")},
 						}
-						assert.Equal(t, expected, items)
+						assert.Equal(t, expected, withoutVersions(items))
 					})
 
 					t.Run(`Range(["a", *)) returns ["a", "b", "c"]`, func(t *testing.T) {
@@ -124,7 +145,7 @@ func TestHarness(t *testing.T, fn StoreFactory) {
 							{K: []byte("b"), V: []byte("value_two")},
 							{K: []byte("c"), V: []byte("value_three")},
 						}
-						assert.Equal(t, expected, items)
+						assert.Equal(t, expected, withoutVersions(items))
 					})
 
 					t.Run(`Range(["a", *), Limit(2)) returns ["a", "b"]`, func(t *testing.T) {
@@ -139,13 +160,384 @@ func TestHarness(t *testing.T, fn StoreFactory) {
 							{K: []byte("a"), V: []byte("value_one")},
 							{K: []byte("b"), V: []byte("value_two")},
 						}
-						assert.Equal(t, expected, items)
+						assert.Equal(t, expected, withoutVersions(items))
+					})
+
+					t.Run(`Cursor(["a", "c")) Next returns ["a", "b"]`, func(t *testing.T) {
+						cursor, err := keyspace.Cursor(
+							ctx,
+							kv.Start([]byte("a")),
+							kv.End([]byte("c")),
+						)
+						require.NoError(t, err)
+						defer cursor.Close()
+
+						expected := []kv.Item{
+							{K: []byte("a"), V: []byte("value_one")},
+							{K: []byte("b"), V: []byte("value_two")},
+						}
+						assert.Equal(t, expected, collect(cursor, cursor.Next))
+						require.NoError(t, cursor.Err())
+					})
+
+					t.Run(`Cursor(["b", *)) Prev returns ["c", "b"]`, func(t *testing.T) {
+						cursor, err := keyspace.Cursor(ctx, kv.Start([]byte("b")))
+						require.NoError(t, err)
+						defer cursor.Close()
+
+						expected := []kv.Item{
+							{K: []byte("c"), V: []byte("value_three")},
+							{K: []byte("b"), V: []byte("value_two")},
+						}
+						assert.Equal(t, expected, collect(cursor, cursor.Prev))
+						require.NoError(t, cursor.Err())
+					})
+
+					t.Run(`Cursor([*, "c")) Prev returns ["b", "a"]`, func(t *testing.T) {
+						cursor, err := keyspace.Cursor(ctx, kv.End([]byte("c")))
+						require.NoError(t, err)
+						defer cursor.Close()
+
+						expected := []kv.Item{
+							{K: []byte("b"), V: []byte("value_two")},
+							{K: []byte("a"), V: []byte("value_one")},
+						}
+						assert.Equal(t, expected, collect(cursor, cursor.Prev))
+						require.NoError(t, cursor.Err())
+					})
+
+					t.Run(`Cursor() Seek("b") then Next and Prev`, func(t *testing.T) {
+						cursor, err := keyspace.Cursor(ctx)
+						require.NoError(t, err)
+						defer cursor.Close()
+
+						require.True(t, cursor.Seek([]byte("b")))
+						assert.Equal(t, kv.Item{K: []byte("b"), V: []byte("value_two")}, withoutVersions([]kv.Item{cursor.Item()})[0])
+
+						require.True(t, cursor.Next())
+						assert.Equal(t, kv.Item{K: []byte("c"), V: []byte("value_three")}, withoutVersions([]kv.Item{cursor.Item()})[0])
+
+						require.True(t, cursor.Prev())
+						require.True(t, cursor.Prev())
+						assert.Equal(t, kv.Item{K: []byte("a"), V: []byte("value_one")}, withoutVersions([]kv.Item{cursor.Item()})[0])
+
+						assert.False(t, cursor.Prev())
+						assert.False(t, cursor.Seek([]byte("d")))
+						require.NoError(t, cursor.Err())
 					})
 
 					return nil
 				})
 			},
 		},
+		{
+			name: `Keyspace("prefixed")`,
+			seed: SeedStore{
+				Keyspaces: []SeedKeyspace{
+					{
+						Name: []byte("prefixed"),
+						Data: [][2][]byte{
+							{[]byte("aa"), []byte("value_one")},
+							{[]byte("ab"), []byte("value_two")},
+							{[]byte("ac"), []byte("value_three")},
+							{[]byte("b"), []byte("value_four")},
+						},
+					},
+				},
+			},
+			test: func(t *testing.T, store kv.Store) {
+				ctx := context.Background()
+
+				store.View(func(view kv.View) error {
+					keyspace, err := view.Keyspace([]byte("prefixed"))
+					require.NoError(t, err)
+
+					t.Run(`Range(Reverse()) returns ["b", "ac", "ab", "aa"]`, func(t *testing.T) {
+						items, err := keyspace.Range(ctx, kv.Reverse())
+						require.NoError(t, err)
+
+						expected := []kv.Item{
+							{K: []byte("b"), V: []byte("value_four")},
+							{K: []byte("ac"), V: []byte("value_three")},
+							{K: []byte("ab"), V: []byte("value_two")},
+							{K: []byte("aa"), V: []byte("value_one")},
+						}
+						assert.Equal(t, expected, withoutVersions(items))
+					})
+
+					t.Run(`Range([*, "ac"), Reverse(), Limit(1)) returns ["ab"]`, func(t *testing.T) {
+						items, err := keyspace.Range(ctx, kv.End([]byte("ac")), kv.Reverse(), kv.Limit(1))
+						require.NoError(t, err)
+
+						expected := []kv.Item{
+							{K: []byte("ab"), V: []byte("value_two")},
+						}
+						assert.Equal(t, expected, withoutVersions(items))
+					})
+
+					t.Run(`Range(Prefix("a"), KeysOnly()) returns ["aa", "ab", "ac"]`, func(t *testing.T) {
+						items, err := keyspace.Range(ctx, kv.Prefix([]byte("a")), kv.KeysOnly())
+						require.NoError(t, err)
+
+						expected := []kv.Item{
+							{K: []byte("aa")},
+							{K: []byte("ab")},
+							{K: []byte("ac")},
+						}
+						assert.Equal(t, expected, withoutVersions(items))
+					})
+
+					t.Run(`Range(["ab", *), Prefix("a")) returns ["ab", "ac"]`, func(t *testing.T) {
+						items, err := keyspace.Range(ctx, kv.Start([]byte("ab")), kv.Prefix([]byte("a")))
+						require.NoError(t, err)
+
+						expected := []kv.Item{
+							{K: []byte("ab"), V: []byte("value_two")},
+							{K: []byte("ac"), V: []byte("value_three")},
+						}
+						assert.Equal(t, expected, withoutVersions(items))
+					})
+
+					t.Run(`Count() returns 4`, func(t *testing.T) {
+						n, err := keyspace.Count(ctx)
+						require.NoError(t, err)
+						assert.Equal(t, 4, n)
+					})
+
+					t.Run(`Count(Prefix("a")) returns 3`, func(t *testing.T) {
+						n, err := keyspace.Count(ctx, kv.Prefix([]byte("a")))
+						require.NoError(t, err)
+						assert.Equal(t, 3, n)
+					})
+
+					t.Run(`Cursor(Prefix("a")) Prev returns ["ac", "ab", "aa"]`, func(t *testing.T) {
+						cursor, err := keyspace.Cursor(ctx, kv.Prefix([]byte("a")), kv.KeysOnly())
+						require.NoError(t, err)
+						defer cursor.Close()
+
+						expected := []kv.Item{
+							{K: []byte("ac")},
+							{K: []byte("ab")},
+							{K: []byte("aa")},
+						}
+						assert.Equal(t, expected, collect(cursor, cursor.Prev))
+						require.NoError(t, cursor.Err())
+					})
+
+					return nil
+				})
+			},
+		},
+		{
+			name: `Keyspace("versioned")`,
+			seed: SeedStore{
+				Keyspaces: []SeedKeyspace{
+					{
+						Name: []byte("versioned"),
+						Data: [][2][]byte{
+							{[]byte("a"), []byte("value_one")},
+						},
+					},
+				},
+			},
+			test: func(t *testing.T, store kv.Store) {
+				ctx := context.Background()
+
+				require.NoError(t, store.Update(func(update kv.Update) error {
+					keyspace, err := update.Keyspace([]byte("versioned"))
+					require.NoError(t, err)
+
+					version := func(key string) int64 {
+						items, err := keyspace.Get(ctx, kv.Key([]byte(key)))
+						require.NoError(t, err)
+						return items[0].Version
+					}
+
+					initial := version("a")
+					require.Greater(t, initial, int64(0))
+
+					t.Run(`Put("a", IfVersion(current)) succeeds`, func(t *testing.T) {
+						require.NoError(t, keyspace.Put(ctx, []byte("a"), []byte("value_two"), kv.IfVersion(initial)))
+						assert.Greater(t, version("a"), initial)
+					})
+
+					t.Run(`Put("a", IfVersion(stale)) returns conflict`, func(t *testing.T) {
+						err := keyspace.Put(ctx, []byte("a"), []byte("value_three"), kv.IfVersion(initial))
+						require.Equal(t, kv.ErrConflict, err)
+
+						items, err := keyspace.Get(ctx, kv.Key([]byte("a")))
+						require.NoError(t, err)
+						assert.Equal(t, []byte("value_two"), items[0].V)
+					})
+
+					t.Run(`Put("b", IfVersion(0)) succeeds only when absent`, func(t *testing.T) {
+						require.NoError(t, keyspace.Put(ctx, []byte("b"), []byte("value_one"), kv.IfVersion(0)))
+						require.Equal(t, kv.ErrConflict, keyspace.Put(ctx, []byte("b"), []byte("value_two"), kv.IfVersion(0)))
+					})
+
+					t.Run(`Put("c", IfPresent()) returns not found`, func(t *testing.T) {
+						require.Equal(t, kv.ErrKeyNotFound, keyspace.Put(ctx, []byte("c"), []byte("value_one"), kv.IfPresent()))
+					})
+
+					t.Run(`Put("c", IfAbsent()) succeeds only when absent`, func(t *testing.T) {
+						require.NoError(t, keyspace.Put(ctx, []byte("c"), []byte("value_one"), kv.IfAbsent()))
+						require.Equal(t, kv.ErrKeyExists, keyspace.Put(ctx, []byte("c"), []byte("value_two"), kv.IfAbsent()))
+					})
+
+					t.Run(`Put("c", IfPresent()) succeeds when present`, func(t *testing.T) {
+						require.NoError(t, keyspace.Put(ctx, []byte("c"), []byte("value_two"), kv.IfPresent()))
+					})
+
+					t.Run(`Range() returns versions matching Get`, func(t *testing.T) {
+						items, err := keyspace.Range(ctx)
+						require.NoError(t, err)
+						require.Len(t, items, 3)
+
+						for _, item := range items {
+							assert.Equal(t, version(string(item.K)), item.Version)
+						}
+					})
+
+					return nil
+				}))
+			},
+		},
+		{
+			name: `Keyspace("batched")`,
+			seed: SeedStore{
+				Keyspaces: []SeedKeyspace{
+					{
+						Name: []byte("batched"),
+						Data: [][2][]byte{
+							{[]byte("a"), []byte("value_one")},
+							{[]byte("b"), []byte("value_two")},
+							{[]byte("c"), []byte("value_three")},
+						},
+					},
+				},
+			},
+			test: func(t *testing.T, store kv.Store) {
+				ctx := context.Background()
+
+				require.NoError(t, store.Update(func(update kv.Update) error {
+					keyspace, err := update.Keyspace([]byte("batched"))
+					require.NoError(t, err)
+
+					t.Run(`PutMany("d", "e", "f", "g", "h") and DeleteMany("a", "b")`, func(t *testing.T) {
+						require.NoError(t, keyspace.PutMany(ctx,
+							kv.Item{K: []byte("d"), V: []byte("value_four")},
+							kv.Item{K: []byte("e"), V: []byte("value_five")},
+							kv.Item{K: []byte("f"), V: []byte("value_six")},
+							kv.Item{K: []byte("g"), V: []byte("value_seven")},
+							kv.Item{K: []byte("h"), V: []byte("value_eight")},
+						))
+
+						require.NoError(t, keyspace.DeleteMany(ctx, []byte("a"), []byte("b")))
+
+						items, err := keyspace.Range(ctx, kv.KeysOnly())
+						require.NoError(t, err)
+
+						expected := []kv.Item{
+							{K: []byte("c")},
+							{K: []byte("d")},
+							{K: []byte("e")},
+							{K: []byte("f")},
+							{K: []byte("g")},
+							{K: []byte("h")},
+						}
+						assert.Equal(t, expected, withoutVersions(items))
+					})
+
+					t.Run(`DeleteRange(["d", "f")) and DeleteRange(Prefix("h"))`, func(t *testing.T) {
+						n, err := keyspace.DeleteRange(ctx, kv.Start([]byte("d")), kv.End([]byte("f")))
+						require.NoError(t, err)
+						assert.Equal(t, 2, n)
+
+						n, err = keyspace.DeleteRange(ctx, kv.Prefix([]byte("h")))
+						require.NoError(t, err)
+						assert.Equal(t, 1, n)
+
+						n, err = keyspace.DeleteRange(ctx, kv.Start([]byte("x")))
+						require.NoError(t, err)
+						assert.Equal(t, 0, n)
+
+						items, err := keyspace.Range(ctx, kv.KeysOnly())
+						require.NoError(t, err)
+
+						expected := []kv.Item{
+							{K: []byte("c")},
+							{K: []byte("f")},
+							{K: []byte("g")},
+						}
+						assert.Equal(t, expected, withoutVersions(items))
+					})
+
+					return nil
+				}))
+			},
+		},
+		{
+			name: `Keyspaces("source", "other")`,
+			seed: SeedStore{
+				Keyspaces: []SeedKeyspace{
+					{
+						Name: []byte("source"),
+						Data: [][2][]byte{
+							{[]byte("a"), []byte("value_one")},
+							{[]byte("b"), []byte("value_two")},
+						},
+					},
+					{
+						Name: []byte("other"),
+						Data: [][2][]byte{
+							{[]byte("x"), []byte("value_x")},
+						},
+					},
+				},
+			},
+			test: func(t *testing.T, store kv.Store) {
+				ctx := context.Background()
+
+				require.NoError(t, store.Update(func(update kv.Update) error {
+					t.Run(`RenameKeyspace("source", "renamed")`, func(t *testing.T) {
+						require.NoError(t, update.RenameKeyspace(ctx, []byte("source"), []byte("renamed")))
+
+						assert.Empty(t, keyspaceItems(t, update, "source"))
+						assert.Equal(t, []kv.Item{
+							{K: []byte("a"), V: []byte("value_one")},
+							{K: []byte("b"), V: []byte("value_two")},
+						}, keyspaceItems(t, update, "renamed"))
+					})
+
+					t.Run(`RenameKeyspace("renamed", "other") returns exists`, func(t *testing.T) {
+						err := update.RenameKeyspace(ctx, []byte("renamed"), []byte("other"))
+						assert.ErrorIs(t, err, kv.ErrKeyspaceExists)
+
+						assert.Len(t, keyspaceItems(t, update, "renamed"), 2)
+					})
+
+					t.Run(`TruncateKeyspace("renamed") retains the keyspace`, func(t *testing.T) {
+						require.NoError(t, update.TruncateKeyspace(ctx, []byte("renamed")))
+						assert.Empty(t, keyspaceItems(t, update, "renamed"))
+
+						keyspace, err := update.Keyspace([]byte("renamed"))
+						require.NoError(t, err)
+
+						require.NoError(t, keyspace.Put(ctx, []byte("c"), []byte("value_three")))
+						assert.Equal(t, []kv.Item{
+							{K: []byte("c"), V: []byte("value_three")},
+						}, keyspaceItems(t, update, "renamed"))
+					})
+
+					t.Run(`DeleteKeyspace("other")`, func(t *testing.T) {
+						require.NoError(t, update.DeleteKeyspace(ctx, []byte("other")))
+						assert.Empty(t, keyspaceItems(t, update, "other"))
+					})
+
+					return nil
+				}))
+			},
+		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			store := fn(t, test.seed)
@@ -154,3 +546,40 @@ func TestHarness(t *testing.T, fn StoreFactory) {
 		})
 	}
 }
+
+// keyspaceItems returns the items within the named keyspace or nil when
+// the keyspace does not exist.
+func keyspaceItems(t *testing.T, update kv.Update, name string) []kv.Item {
+	t.Helper()
+
+	keyspace, err := update.Keyspace([]byte(name))
+	if errors.Is(err, kv.ErrKeyspaceNotFound) {
+		return nil
+	}
+
+	require.NoError(t, err)
+
+	items, err := keyspace.Range(context.Background())
+	require.NoError(t, err)
+
+	return withoutVersions(items)
+}
+
+// collect returns the item at the cursor for each successful call to move.
+func collect(cursor kv.Cursor, move func() bool) (items []kv.Item) {
+	for move() {
+		items = append(items, cursor.Item())
+	}
+
+	return withoutVersions(items)
+}
+
+// withoutVersions clears the versions of the provided items so that
+// they can be compared independently of the backend.
+func withoutVersions(items []kv.Item) []kv.Item {
+	for i := range items {
+		items[i].Version = 0
+	}
+
+	return items
+}
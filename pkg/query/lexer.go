@@ -0,0 +1,126 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of query"
+	}
+
+	return fmt.Sprintf("%q", t.text)
+}
+
+// is reports whether the token is the provided keyword (case-insensitive).
+func (t token) is(keyword string) bool {
+	return t.kind == tokenIdent && strings.EqualFold(t.text, keyword)
+}
+
+// lex splits the query into tokens.
+func lex(src string) (tokens []token, err error) {
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokenComma, ",", i})
+			i++
+		case c == '=':
+			tokens = append(tokens, token{tokenOperator, "=", i})
+			i++
+		case c == '!' || c == '<' || c == '>':
+			op := string(c)
+			if i+1 < len(src) && src[i+1] == '=' {
+				op += "="
+			} else if c == '!' {
+				return nil, &SyntaxError{Pos: i, Msg: "expected != operator"}
+			}
+
+			tokens = append(tokens, token{tokenOperator, op, i})
+			i += len(op)
+		case c == '"':
+			s, n, err := lexString(src[i:])
+			if err != nil {
+				return nil, &SyntaxError{Pos: i, Msg: err.Error()}
+			}
+
+			tokens = append(tokens, token{tokenString, s, i})
+			i += n
+		case c == '-' || c == '.' || unicode.IsDigit(c):
+			start := i
+			for i++; i < len(src) && strings.ContainsRune("0123456789.eE+-", rune(src[i])); i++ {
+			}
+
+			tokens = append(tokens, token{tokenNumber, src[start:i], start})
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i++; i < len(src) && (src[i] == '_' || src[i] == '.' || unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))); i++ {
+			}
+
+			tokens = append(tokens, token{tokenIdent, src[start:i], start})
+		default:
+			return nil, &SyntaxError{Pos: i, Msg: fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(src)}), nil
+}
+
+// lexString reads a double quoted string supporting \" and \\ escapes.
+// It returns the unquoted string and the number of bytes consumed.
+func lexString(src string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(src); i++ {
+		switch src[i] {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			if i+1 >= len(src) {
+				break
+			}
+
+			i++
+			switch src[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(src[i])
+			}
+		default:
+			b.WriteByte(src[i])
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,231 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// SyntaxError is returned when a query cannot be parsed.
+type SyntaxError struct {
+	// Pos is the byte offset within the query at which the error occurred.
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+// Parse parses the query string into a Query.
+func Parse(src string) (*Query, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+
+	q := &Query{}
+	if !p.peek().is("ORDER") && !p.peek().is("LIMIT") && p.peek().kind != tokenEOF {
+		if q.Where, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.peek().is("ORDER") {
+		p.next()
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+
+		for {
+			field := p.next()
+			if field.kind != tokenIdent {
+				return nil, p.errorf(field, "expected field, found %s", field)
+			}
+
+			order := Order{Field: field.text}
+			if p.peek().is("DESC") {
+				p.next()
+				order.Desc = true
+			} else if p.peek().is("ASC") {
+				p.next()
+			}
+
+			q.OrderBy = append(q.OrderBy, order)
+
+			if p.peek().kind != tokenComma {
+				break
+			}
+
+			p.next()
+		}
+	}
+
+	if p.peek().is("LIMIT") {
+		p.next()
+
+		limit := p.next()
+		n, err := strconv.Atoi(limit.text)
+		if limit.kind != tokenNumber || err != nil || n < 1 {
+			return nil, p.errorf(limit, "expected positive integer limit, found %s", limit)
+		}
+
+		q.Limit = n
+	}
+
+	if tok := p.next(); tok.kind != tokenEOF {
+		return nil, p.errorf(tok, "unexpected %s", tok)
+	}
+
+	return q, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+
+	return tok
+}
+
+func (p *parser) errorf(tok token, format string, args ...any) error {
+	return &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) expectKeyword(keyword string) error {
+	if tok := p.next(); !tok.is(keyword) {
+		return p.errorf(tok, "expected %s, found %s", keyword, tok)
+	}
+
+	return nil
+}
+
+// parseOr parses: and { OR and }
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().is("OR") {
+		p.next()
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		left = Or{Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+// parseAnd parses: unary { AND unary }
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().is("AND") {
+		p.next()
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		left = And{Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+// parseUnary parses: NOT unary | ( or ) | comparison
+func (p *parser) parseUnary() (Expr, error) {
+	tok := p.peek()
+	switch {
+	case tok.is("NOT"):
+		p.next()
+
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return Not{Expr: expr}, nil
+	case tok.kind == tokenLParen:
+		p.next()
+
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if tok := p.next(); tok.kind != tokenRParen {
+			return nil, p.errorf(tok, "expected ), found %s", tok)
+		}
+
+		return expr, nil
+	}
+
+	return p.parseComparison()
+}
+
+// parseComparison parses: field operator literal
+func (p *parser) parseComparison() (Expr, error) {
+	field := p.next()
+	if field.kind != tokenIdent || isKeyword(field) {
+		return nil, p.errorf(field, "expected field, found %s", field)
+	}
+
+	op := p.next()
+	if op.kind != tokenOperator {
+		return nil, p.errorf(op, "expected comparison operator, found %s", op)
+	}
+
+	literal := p.next()
+
+	var value any
+	switch {
+	case literal.kind == tokenString:
+		value = literal.text
+	case literal.kind == tokenNumber:
+		f, err := strconv.ParseFloat(literal.text, 64)
+		if err != nil {
+			return nil, p.errorf(literal, "invalid number %s", literal)
+		}
+
+		value = f
+	case literal.is("true"):
+		value = true
+	case literal.is("false"):
+		value = false
+	case literal.is("null"):
+		value = nil
+	default:
+		return nil, p.errorf(literal, "expected literal, found %s", literal)
+	}
+
+	return Comparison{Field: field.text, Op: Operator(op.text), Value: value}, nil
+}
+
+func isKeyword(tok token) bool {
+	for _, keyword := range []string{"AND", "OR", "NOT", "ORDER", "BY", "LIMIT", "ASC", "DESC"} {
+		if tok.is(keyword) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,282 @@
+// Package query implements a small declarative query language over JSON documents.
+//
+// A query consists of an optional filter expression followed by optional
+// ORDER BY and LIMIT clauses:
+//
+//	author = "bob" AND rating >= 4 ORDER BY created DESC LIMIT 20
+//
+// Filters compare fields with literals using =, !=, <, <=, > and >= and can be
+// combined using AND, OR, NOT and parentheses. Fields name the keys of JSON
+// objects and nested fields are separated by dots (e.g. address.city).
+// Literals are double quoted strings, numbers, true, false and null.
+// Keywords are case-insensitive.
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Query is a parsed query.
+type Query struct {
+	// Where is the filter expression. It is nil when every document matches.
+	Where Expr
+	// OrderBy is the sequence of fields by which matching documents are sorted.
+	OrderBy []Order
+	// Limit is the maximum number of documents returned. Zero means unlimited.
+	Limit int
+}
+
+// Order is a single ORDER BY term.
+type Order struct {
+	Field string
+	Desc  bool
+}
+
+func (o Order) String() string {
+	if o.Desc {
+		return o.Field + " DESC"
+	}
+
+	return o.Field + " ASC"
+}
+
+// Expr is a node of a filter expression.
+type Expr interface {
+	// Match reports whether the JSON document satisfies the expression.
+	Match(doc map[string]any) bool
+	String() string
+}
+
+// And matches documents which satisfy both Left and Right.
+type And struct{ Left, Right Expr }
+
+func (e And) Match(doc map[string]any) bool { return e.Left.Match(doc) && e.Right.Match(doc) }
+func (e And) String() string                { return "(" + e.Left.String() + " AND " + e.Right.String() + ")" }
+
+// Or matches documents which satisfy either Left or Right.
+type Or struct{ Left, Right Expr }
+
+func (e Or) Match(doc map[string]any) bool { return e.Left.Match(doc) || e.Right.Match(doc) }
+func (e Or) String() string                { return "(" + e.Left.String() + " OR " + e.Right.String() + ")" }
+
+// Not matches documents which do not satisfy Expr.
+type Not struct{ Expr Expr }
+
+func (e Not) Match(doc map[string]any) bool { return !e.Expr.Match(doc) }
+func (e Not) String() string                { return "NOT " + e.Expr.String() }
+
+// Operator is a comparison operator.
+type Operator string
+
+const (
+	Eq  Operator = "="
+	Neq Operator = "!="
+	Lt  Operator = "<"
+	Lte Operator = "<="
+	Gt  Operator = ">"
+	Gte Operator = ">="
+)
+
+// Comparison compares the value of a field with a literal.
+// Value is one of string, float64, bool or nil.
+type Comparison struct {
+	Field string
+	Op    Operator
+	Value any
+}
+
+func (e Comparison) String() string {
+	v, _ := json.Marshal(e.Value)
+	return fmt.Sprintf("%s %s %s", e.Field, e.Op, v)
+}
+
+// Match compares the field of the document with the literal value.
+// A missing field is treated as null. Ordering operators only match when
+// both values are numbers or both are strings.
+func (e Comparison) Match(doc map[string]any) bool {
+	v, _ := Field(doc, e.Field)
+
+	switch e.Op {
+	case Eq:
+		return equal(v, e.Value)
+	case Neq:
+		return !equal(v, e.Value)
+	}
+
+	cmp, ok := compare(v, e.Value)
+	if !ok {
+		return false
+	}
+
+	switch e.Op {
+	case Lt:
+		return cmp < 0
+	case Lte:
+		return cmp <= 0
+	case Gt:
+		return cmp > 0
+	case Gte:
+		return cmp >= 0
+	}
+
+	return false
+}
+
+// Field returns the value of the dot separated field within doc.
+func Field(doc map[string]any, field string) (any, bool) {
+	var v any = doc
+	for _, part := range strings.Split(field, ".") {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+
+		if v, ok = obj[part]; !ok {
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+func equal(a, b any) bool {
+	if cmp, ok := compare(a, b); ok {
+		return cmp == 0
+	}
+
+	switch a := a.(type) {
+	case bool:
+		b, ok := b.(bool)
+		return ok && a == b
+	case nil:
+		return b == nil
+	}
+
+	return false
+}
+
+// compare orders two numbers or two strings.
+func compare(a, b any) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		b, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+
+		return 0, true
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+
+		return strings.Compare(a, b), true
+	}
+
+	return 0, false
+}
+
+// Match reports whether the document satisfies the filter of the query.
+func (q *Query) Match(doc map[string]any) bool {
+	return q.Where == nil || q.Where.Match(doc)
+}
+
+// Sort sorts docs according to the ORDER BY clause of the query.
+// The sort is stable so documents which compare equal retain their order.
+// Values which cannot be compared (e.g. missing fields) sort last.
+func (q *Query) Sort(docs []map[string]any) []int {
+	order := make([]int, len(docs))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		for _, o := range q.OrderBy {
+			a, _ := Field(docs[order[i]], o.Field)
+			b, _ := Field(docs[order[j]], o.Field)
+
+			cmp, ok := compare(a, b)
+			if !ok {
+				_, aok := compare(a, a)
+				_, bok := compare(b, b)
+				if aok == bok {
+					continue
+				}
+
+				// comparable values sort before incomparable ones
+				return aok
+			}
+
+			if cmp == 0 {
+				continue
+			}
+
+			if o.Desc {
+				return cmp > 0
+			}
+
+			return cmp < 0
+		}
+
+		return false
+	})
+
+	return order
+}
+
+// Conjuncts returns the expressions which are combined using AND at the
+// top level of the filter. Each of them must match for the filter to match.
+func (q *Query) Conjuncts() []Expr {
+	var conjuncts []Expr
+
+	var walk func(Expr)
+	walk = func(e Expr) {
+		if and, ok := e.(And); ok {
+			walk(and.Left)
+			walk(and.Right)
+			return
+		}
+
+		conjuncts = append(conjuncts, e)
+	}
+
+	if q.Where != nil {
+		walk(q.Where)
+	}
+
+	return conjuncts
+}
+
+func (q *Query) String() string {
+	var parts []string
+	if q.Where != nil {
+		parts = append(parts, q.Where.String())
+	}
+
+	if len(q.OrderBy) > 0 {
+		orders := make([]string, len(q.OrderBy))
+		for i, o := range q.OrderBy {
+			orders[i] = o.String()
+		}
+
+		parts = append(parts, "ORDER BY "+strings.Join(orders, ", "))
+	}
+
+	if q.Limit > 0 {
+		parts = append(parts, "LIMIT "+strconv.Itoa(q.Limit))
+	}
+
+	return strings.Join(parts, " ")
+}
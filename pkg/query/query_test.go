@@ -0,0 +1,144 @@
+package query
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		query    string
+		expected *Query
+	}{
+		{
+			query: `author = "bob" AND rating >= 4 ORDER BY created DESC LIMIT 20`,
+			expected: &Query{
+				Where: And{
+					Left:  Comparison{Field: "author", Op: Eq, Value: "bob"},
+					Right: Comparison{Field: "rating", Op: Gte, Value: float64(4)},
+				},
+				OrderBy: []Order{{Field: "created", Desc: true}},
+				Limit:   20,
+			},
+		},
+		{
+			query: `a = 1 or b != "x\"y" and not (c < -2.5 OR d = null)`,
+			expected: &Query{
+				Where: Or{
+					Left: Comparison{Field: "a", Op: Eq, Value: float64(1)},
+					Right: And{
+						Left: Comparison{Field: "b", Op: Neq, Value: `x"y`},
+						Right: Not{Expr: Or{
+							Left:  Comparison{Field: "c", Op: Lt, Value: -2.5},
+							Right: Comparison{Field: "d", Op: Eq, Value: nil},
+						}},
+					},
+				},
+			},
+		},
+		{
+			query: `address.city = "london" AND active = true ORDER BY name, age desc`,
+			expected: &Query{
+				Where: And{
+					Left:  Comparison{Field: "address.city", Op: Eq, Value: "london"},
+					Right: Comparison{Field: "active", Op: Eq, Value: true},
+				},
+				OrderBy: []Order{{Field: "name"}, {Field: "age", Desc: true}},
+			},
+		},
+		{
+			query:    ``,
+			expected: &Query{},
+		},
+		{
+			query:    `LIMIT 5`,
+			expected: &Query{Limit: 5},
+		},
+	} {
+		t.Run(test.query, func(t *testing.T) {
+			q, err := Parse(test.query)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, q)
+		})
+	}
+}
+
+func TestParse_SyntaxError(t *testing.T) {
+	for query, pos := range map[string]int{
+		`author =`:              8,
+		`author "bob"`:          7,
+		`author = "bob`:         9,
+		`(a = 1`:                6,
+		`a = 1 LIMIT 0`:         12,
+		`a = 1 ORDER created`:   12,
+		`a ! 1`:                 2,
+		`a = 1 b = 2`:           6,
+		`AND = 1`:               0,
+		`a = 1 ORDER BY`:        14,
+		`a = bob`:               4,
+		`a = 1 LIMIT 5 LIMIT 6`: 14,
+	} {
+		t.Run(query, func(t *testing.T) {
+			_, err := Parse(query)
+
+			var syntaxErr *SyntaxError
+			require.ErrorAs(t, err, &syntaxErr)
+			assert.Equal(t, pos, syntaxErr.Pos)
+		})
+	}
+}
+
+func TestQuery_Match(t *testing.T) {
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"author": "bob",
+		"rating": 4,
+		"active": true,
+		"address": {"city": "london"},
+		"deleted": null
+	}`), &doc))
+
+	for query, expected := range map[string]bool{
+		`author = "bob"`:                       true,
+		`author != "bob"`:                      false,
+		`rating >= 4 AND rating < 5`:           true,
+		`rating > 4 OR author < "c"`:           true,
+		`rating > "4"`:                         false,
+		`active = true`:                        true,
+		`address.city = "london"`:              true,
+		`address.postcode = null`:              true,
+		`deleted = null`:                       true,
+		`author = null`:                        false,
+		`missing > 1`:                          false,
+		`NOT (author = "bob" AND rating = 4)`:  false,
+		`ORDER BY author`:                      true,
+		`author.first = "bob"`:                 false,
+		`rating = 4.0 AND author >= "bob"`:     true,
+		`not not active = false or rating = 4`: true,
+	} {
+		t.Run(query, func(t *testing.T) {
+			q, err := Parse(query)
+			require.NoError(t, err)
+			assert.Equal(t, expected, q.Match(doc))
+		})
+	}
+}
+
+func TestQuery_Sort(t *testing.T) {
+	var docs []map[string]any
+	require.NoError(t, json.Unmarshal([]byte(`[
+		{"name": "c", "age": 30},
+		{"name": "a", "age": 30},
+		{"name": "b"},
+		{"name": "d", "age": 20}
+	]`), &docs))
+
+	q, err := Parse(`ORDER BY age DESC, name`)
+	require.NoError(t, err)
+
+	// missing values sort last regardless of direction
+	assert.Equal(t, []int{1, 0, 3, 2}, q.Sort(docs))
+}
@@ -0,0 +1,176 @@
+package dokvs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/georgemac/dokvs/pkg/query"
+)
+
+// Plan describes how a query is executed against a collection.
+// It is returned by CollectionView.Explain.
+type Plan struct {
+	Query *query.Query
+	// Collection is the name of the collection queried.
+	Collection string
+	// Index is the name of the field index used to select candidate documents.
+	// It is empty when every document in the collection is scanned.
+	Index string
+	// Value is the value looked up in Index.
+	Value any
+}
+
+// String returns a human readable description of the plan.
+func (p Plan) String() string {
+	var steps []string
+	if p.Index != "" {
+		value, _ := json.Marshal(p.Value)
+		steps = append(steps, fmt.Sprintf("INDEX LOOKUP %s (%s = %s)", p.Collection, p.Index, value))
+	} else {
+		steps = append(steps, fmt.Sprintf("SCAN %s", p.Collection))
+	}
+
+	if p.Query.Where != nil {
+		steps = append(steps, fmt.Sprintf("FILTER %s", p.Query.Where))
+	}
+
+	if len(p.Query.OrderBy) > 0 {
+		orders := make([]string, len(p.Query.OrderBy))
+		for i, o := range p.Query.OrderBy {
+			orders[i] = o.String()
+		}
+
+		steps = append(steps, "SORT "+strings.Join(orders, ", "))
+	}
+
+	if p.Query.Limit > 0 {
+		steps = append(steps, fmt.Sprintf("LIMIT %d", p.Query.Limit))
+	}
+
+	return strings.Join(steps, " -> ")
+}
+
+// Explain parses the query and returns the plan with which Query would execute it.
+// See package query for the syntax of queries.
+func (c CollectionView[D, K]) Explain(q string) (Plan, error) {
+	parsed, err := query.Parse(q)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	return c.plan(parsed), nil
+}
+
+// plan selects a field index for the first equality comparison in the
+// top-level conjunction of the query for which one is configured.
+func (c CollectionView[D, K]) plan(q *query.Query) Plan {
+	plan := Plan{Query: q, Collection: string(c.schema.Collection())}
+	for _, expr := range q.Conjuncts() {
+		cmp, ok := expr.(query.Comparison)
+		if !ok || cmp.Op != query.Eq || cmp.Value == nil {
+			continue
+		}
+
+		for _, idx := range c.indexes {
+			if idx.Field != "" && idx.Field == cmp.Field {
+				plan.Index, plan.Value = idx.Name, cmp.Value
+				return plan
+			}
+		}
+	}
+
+	return plan
+}
+
+// Query returns the documents which match the query, in the order requested.
+// Documents are matched against their JSON encoding, so Query is intended
+// for collections which use the JSONSerializer.
+// Candidate documents are looked up in a field index (see WithFieldIndex)
+// when the query requires equality on an indexed field and are otherwise
+// found by scanning the entire collection.
+// See package query for the syntax of queries.
+func (c CollectionView[D, K]) Query(ctx context.Context, q string) ([]D, error) {
+	parsed, err := query.Parse(q)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		plan = c.plan(parsed)
+		ds   []D
+		docs []map[string]any
+	)
+
+	// results can only be truncated during iteration when they needn't be sorted
+	done := func() bool {
+		return len(parsed.OrderBy) == 0 && parsed.Limit > 0 && len(ds) >= parsed.Limit
+	}
+
+	match := func(d D) error {
+		doc, err := jsonDocument(d)
+		if err != nil {
+			return err
+		}
+
+		if !parsed.Match(doc) {
+			return nil
+		}
+
+		ds, docs = append(ds, d), append(docs, doc)
+		if done() {
+			return errStopIteration
+		}
+
+		return nil
+	}
+
+	if plan.Index != "" {
+		candidates, err := c.Lookup(ctx, plan.Index, fieldIndexValue(plan.Value))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range candidates {
+			if err := match(d); errors.Is(err, errStopIteration) {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	} else if err := c.iterate(ctx, match); err != nil {
+		return nil, err
+	}
+
+	if len(parsed.OrderBy) > 0 {
+		sorted := make([]D, len(ds))
+		for i, j := range parsed.Sort(docs) {
+			sorted[i] = ds[j]
+		}
+
+		ds = sorted
+	}
+
+	if parsed.Limit > 0 && len(ds) > parsed.Limit {
+		ds = ds[:parsed.Limit]
+	}
+
+	return ds, nil
+}
+
+// jsonDocument returns the JSON encoding of d decoded as a generic object.
+func jsonDocument[D any](d D) (map[string]any, error) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
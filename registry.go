@@ -0,0 +1,78 @@
+package dokvs
+
+// Registry is a set of serializers keyed by their ID (see IdentifiedSerializer)
+// with which a collection reads values written in formats other than that of
+// its configured serializer. It allows the serializer of a collection to be
+// changed without rewriting the documents already stored.
+type Registry[D any] struct {
+	serializers []Serializer[D]
+	byID        map[string]Serializer[D]
+}
+
+// NewRegistry returns a Registry of the provided serializers.
+// The first serializer reads values written without a format ID,
+// such as those written before the registry was configured.
+func NewRegistry[D any](serializers ...Serializer[D]) *Registry[D] {
+	r := &Registry[D]{byID: map[string]Serializer[D]{}}
+	for _, serializer := range serializers {
+		r.Register(serializer)
+	}
+
+	return r
+}
+
+// Register adds the serializer to the registry, replacing any with the same ID
+// in its place such that the order of registration is otherwise unchanged.
+func (r *Registry[D]) Register(serializer Serializer[D]) {
+	id := serializerID(serializer)
+	if _, ok := r.byID[id]; ok {
+		for i, registered := range r.serializers {
+			if serializerID(registered) == id {
+				r.serializers[i] = serializer
+			}
+		}
+	} else {
+		r.serializers = append(r.serializers, serializer)
+	}
+
+	r.byID[id] = serializer
+}
+
+// Lookup returns the serializer with the provided ID.
+func (r *Registry[D]) Lookup(id string) (Serializer[D], bool) {
+	serializer, ok := r.byID[id]
+	return serializer, ok
+}
+
+// IDs returns the ID of each serializer in the order registered.
+func (r *Registry[D]) IDs() (ids []string) {
+	for _, serializer := range r.serializers {
+		ids = append(ids, serializerID(serializer))
+	}
+
+	return
+}
+
+// untagged returns the serializer which reads values without a format ID,
+// or fallback when the registry is empty.
+func (r *Registry[D]) untagged(fallback Serializer[D]) Serializer[D] {
+	if len(r.serializers) == 0 {
+		return fallback
+	}
+
+	return r.serializers[0]
+}
+
+// WithRegistry configures the registry of serializers with which the
+// collection reads values written in other formats, and makes the values
+// it writes self-describing: their envelope records the ID of the configured
+// serializer along with a checksum of the payload, which is verified on read.
+//
+// The configured serializer need not be in the registry, and values written
+// by it are read using it. Collection.Migrate rewrites values written in
+// other formats using the configured serializer.
+func WithRegistry[D any, K AnyBytes](registry *Registry[D]) func(*Collection[D, K]) {
+	return func(c *Collection[D, K]) {
+		c.registry = registry
+	}
+}
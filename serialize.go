@@ -1,9 +1,38 @@
 package dokvs
 
-import "encoding/json"
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// IdentifiedSerializer is implemented by serializers which report a stable
+// identifier for their encoding. The identifier is recorded in the catalog
+// when a collection is initialized, such that a collection cannot later be
+// opened using a different encoding.
+type IdentifiedSerializer interface {
+	ID() string
+}
+
+// serializerID returns the identifier of s, falling back to its type name
+// for serializers which do not implement IdentifiedSerializer.
+func serializerID(s any) string {
+	if id, ok := s.(IdentifiedSerializer); ok {
+		return id.ID()
+	}
+
+	return fmt.Sprintf("%T", s)
+}
 
 type JSONSerializer[T any] struct{}
 
+func (s JSONSerializer[T]) ID() string { return "json" }
+
 func (s JSONSerializer[T]) Serialize(t T) ([]byte, error) {
 	return json.Marshal(t)
 }
@@ -11,3 +40,130 @@ func (s JSONSerializer[T]) Serialize(t T) ([]byte, error) {
 func (d JSONSerializer[T]) Deserialize(v []byte, t *T) error {
 	return json.Unmarshal(v, t)
 }
+
+// DeserializeFields deserializes only the named top-level fields of the JSON object v.
+// The values of all other fields are skipped without being decoded.
+func (d JSONSerializer[T]) DeserializeFields(v []byte, t *T, fields []string) error {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(v, &all); err != nil {
+		return err
+	}
+
+	projected := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		if raw, ok := all[field]; ok {
+			projected[field] = raw
+		}
+	}
+
+	p, err := json.Marshal(projected)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(p, t)
+}
+
+// ProtoSerializer serializes documents which are protobuf messages using the
+// protobuf binary wire format. Unknown fields are retained across a
+// Deserialize and Serialize, such that documents written by newer versions
+// of a message are not truncated when rewritten by older ones.
+// Serialization is deterministic, such that equal messages are serialized
+// to equal bytes.
+type ProtoSerializer[T proto.Message] struct{}
+
+func (s ProtoSerializer[T]) ID() string { return "protobuf" }
+
+func (s ProtoSerializer[T]) Serialize(t T) ([]byte, error) {
+	return proto.MarshalOptions{Deterministic: true}.Marshal(t)
+}
+
+func (s ProtoSerializer[T]) Deserialize(v []byte, t *T) error {
+	msg := newMessage(*t)
+	if err := proto.Unmarshal(v, msg); err != nil {
+		return err
+	}
+
+	*t = msg
+
+	return nil
+}
+
+// ProtoJSONSerializer serializes documents which are protobuf messages using
+// the canonical protobuf JSON mapping. It is intended for debugging, as values
+// are readable in the store, and unlike ProtoSerializer it discards unknown fields.
+type ProtoJSONSerializer[T proto.Message] struct{}
+
+func (s ProtoJSONSerializer[T]) ID() string { return "protojson" }
+
+func (s ProtoJSONSerializer[T]) Serialize(t T) ([]byte, error) {
+	return protojson.Marshal(t)
+}
+
+func (s ProtoJSONSerializer[T]) Deserialize(v []byte, t *T) error {
+	msg := newMessage(*t)
+	if err := protojson.Unmarshal(v, msg); err != nil {
+		return err
+	}
+
+	*t = msg
+
+	return nil
+}
+
+// newMessage returns a new empty message of the same type as t.
+// Generated messages support calling ProtoReflect on a nil pointer,
+// so t may be the zero value of T.
+func newMessage[T proto.Message](t T) T {
+	return t.ProtoReflect().New().Interface().(T)
+}
+
+// GobSerializer serializes documents using encoding/gob. Each document is
+// encoded independently and so carries its own type description.
+// Encoding is only deterministic for documents which do not contain maps.
+type GobSerializer[T any] struct{}
+
+func (s GobSerializer[T]) ID() string { return "gob" }
+
+func (s GobSerializer[T]) Serialize(t T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(t); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (s GobSerializer[T]) Deserialize(v []byte, t *T) error {
+	return gob.NewDecoder(bytes.NewReader(v)).Decode(t)
+}
+
+// cborEncMode encodes values using the core deterministic encoding of
+// RFC 8949, which sorts map keys and uses the shortest form of each value.
+// Times are encoded as RFC 3339 strings to retain their precision.
+var cborEncMode = func() cbor.EncMode {
+	opts := cbor.CoreDetEncOptions()
+	opts.Time = cbor.TimeRFC3339Nano
+
+	mode, err := opts.EncMode()
+	if err != nil {
+		panic(err)
+	}
+
+	return mode
+}()
+
+// CBORSerializer serializes documents using deterministic CBOR (RFC 8949),
+// such that equal documents are always serialized to equal bytes.
+// Struct fields are named using their cbor tags, falling back to their json tags.
+type CBORSerializer[T any] struct{}
+
+func (s CBORSerializer[T]) ID() string { return "cbor" }
+
+func (s CBORSerializer[T]) Serialize(t T) ([]byte, error) {
+	return cborEncMode.Marshal(t)
+}
+
+func (s CBORSerializer[T]) Deserialize(v []byte, t *T) error {
+	return cbor.Unmarshal(v, t)
+}
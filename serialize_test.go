@@ -0,0 +1,223 @@
+package dokvs_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/georgemac/dokvs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestProtoSerializer_RetainsUnknownFields(t *testing.T) {
+	var serializer dokvs.ProtoSerializer[*wrapperspb.StringValue]
+
+	v, err := serializer.Serialize(wrapperspb.String("value"))
+	require.NoError(t, err)
+
+	// a field unknown to StringValue, as written by a newer version of a message
+	v = protowire.AppendTag(v, 15, protowire.VarintType)
+	v = protowire.AppendVarint(v, 42)
+
+	var doc *wrapperspb.StringValue
+	require.NoError(t, serializer.Deserialize(v, &doc))
+	assert.Equal(t, "value", doc.GetValue())
+
+	rewritten, err := serializer.Serialize(doc)
+	require.NoError(t, err)
+	assert.Equal(t, v, rewritten)
+}
+
+type Listing struct {
+	ID      string            `json:"id"`
+	Price   float64           `json:"price"`
+	Tags    []string          `json:"tags"`
+	Labels  map[string]string `json:"labels"`
+	Created time.Time         `json:"created"`
+}
+
+func TestSerializers_RoundTrip(t *testing.T) {
+	listing := Listing{
+		ID:      "a",
+		Price:   12.5,
+		Tags:    []string{"new", "sale"},
+		Labels:  map[string]string{"colour": "red", "size": "large", "brand": "acme"},
+		Created: time.Date(2022, 1, 2, 3, 4, 5, 6, time.UTC),
+	}
+
+	for name, serializer := range map[string]dokvs.Serializer[Listing]{
+		"json": dokvs.JSONSerializer[Listing]{},
+		"gob":  dokvs.GobSerializer[Listing]{},
+		"cbor": dokvs.CBORSerializer[Listing]{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			v, err := serializer.Serialize(listing)
+			require.NoError(t, err)
+
+			var found Listing
+			require.NoError(t, serializer.Deserialize(v, &found))
+			assert.Equal(t, listing, found)
+		})
+	}
+}
+
+func TestCBORSerializer_Deterministic(t *testing.T) {
+	var (
+		serializer dokvs.CBORSerializer[map[string]any]
+		doc        = map[string]any{}
+	)
+
+	for _, k := range []string{"zulu", "alpha", "mike", "bravo", "yankee", "charlie", "x", "delta"} {
+		doc[k] = map[string]any{"value": k, "length": len(k)}
+	}
+
+	expected, err := serializer.Serialize(doc)
+	require.NoError(t, err)
+
+	// map iteration order is randomized so repeated encodings exercise ordering
+	for i := 0; i < 20; i++ {
+		v, err := serializer.Serialize(doc)
+		require.NoError(t, err)
+		require.Equal(t, expected, v)
+	}
+
+	json, err := dokvs.JSONSerializer[map[string]any]{}.Serialize(doc)
+	require.NoError(t, err)
+	assert.Less(t, len(expected), len(json))
+}
+
+func TestCompressedSerializer(t *testing.T) {
+	var (
+		small = Listing{ID: "small", Tags: []string{"a"}}
+		large = Listing{ID: "large"}
+		json  = dokvs.JSONSerializer[Listing]{}
+	)
+
+	for i := 0; i < 100; i++ {
+		large.Tags = append(large.Tags, "highly compressible tag")
+	}
+
+	uncompressed, err := json.Serialize(large)
+	require.NoError(t, err)
+
+	compressions := []dokvs.Compression{
+		dokvs.CompressionNone,
+		dokvs.CompressionGzip,
+		dokvs.CompressionZlib,
+		dokvs.CompressionFlate,
+	}
+
+	serializers := make([]dokvs.CompressedSerializer[Listing], len(compressions))
+	for i, compression := range compressions {
+		serializers[i] = dokvs.NewCompressedSerializer[Listing](json, compression,
+			dokvs.WithCompressionThreshold[Listing](256))
+	}
+
+	for i, serializer := range serializers {
+		t.Run(compressions[i].String(), func(t *testing.T) {
+			v, err := serializer.Serialize(small)
+			require.NoError(t, err)
+			assert.Equal(t, byte(dokvs.CompressionNone), v[0], "values below the threshold are not compressed")
+
+			var found Listing
+			require.NoError(t, serializer.Deserialize(v, &found))
+			assert.Equal(t, small, found)
+
+			v, err = serializer.Serialize(large)
+			require.NoError(t, err)
+			assert.Equal(t, byte(compressions[i]), v[0])
+
+			if compressions[i] != dokvs.CompressionNone {
+				assert.Less(t, len(v), len(uncompressed))
+			}
+
+			// values are readable regardless of the compression they were written with
+			for _, reader := range serializers {
+				var found Listing
+				require.NoError(t, reader.Deserialize(v, &found))
+				assert.Equal(t, large, found)
+			}
+		})
+	}
+
+	var found Listing
+	err = serializers[0].Deserialize([]byte{0xFF, '{', '}'}, &found)
+	assert.ErrorIs(t, err, dokvs.ErrUnknownCompression)
+
+	// values which decompress beyond the configured maximum are rejected
+	v, err := serializers[1].Serialize(large)
+	require.NoError(t, err)
+
+	limited := dokvs.NewCompressedSerializer[Listing](json, dokvs.CompressionGzip,
+		dokvs.WithMaxDecompressedSize[Listing](int64(len(uncompressed)-1)))
+	assert.ErrorIs(t, limited.Deserialize(v, &found), dokvs.ErrDecompressedTooLarge)
+	assert.ErrorIs(t, limited.DeserializeFields(v, &found, []string{"id"}), dokvs.ErrDecompressedTooLarge)
+
+	limited = dokvs.NewCompressedSerializer[Listing](json, dokvs.CompressionGzip,
+		dokvs.WithMaxDecompressedSize[Listing](int64(len(uncompressed))))
+	require.NoError(t, limited.Deserialize(v, &found))
+	assert.Equal(t, large, found)
+
+	assert.Equal(t, "compressed:json", serializers[1].ID())
+}
+
+func TestRegistry_Register(t *testing.T) {
+	var (
+		json       = dokvs.JSONSerializer[Listing]{}
+		compressed = dokvs.NewCompressedSerializer[Listing](json, dokvs.CompressionNone)
+		gzipped    = dokvs.NewCompressedSerializer[Listing](json, dokvs.CompressionGzip)
+		registry   = dokvs.NewRegistry[Listing](compressed, json)
+	)
+
+	// registering an existing ID replaces the serializer in its place
+	registry.Register(gzipped)
+	registry.Register(dokvs.CBORSerializer[Listing]{})
+
+	assert.Equal(t, []string{"compressed:json", "json", "cbor"}, registry.IDs())
+
+	serializer, ok := registry.Lookup("compressed:json")
+	require.True(t, ok)
+	assert.Equal(t, dokvs.Serializer[Listing](gzipped), serializer)
+}
+
+func TestEncryptedSerializer(t *testing.T) {
+	var (
+		listing = Listing{ID: "a", Tags: []string{"secret"}}
+		keys    = dokvs.StaticKeyProvider{
+			Current: "k1",
+			Keys: map[string][]byte{
+				"k1": bytes.Repeat([]byte{1}, 32),
+				"k2": bytes.Repeat([]byte{2}, 16),
+			},
+		}
+		serializer = dokvs.NewEncryptedSerializer[Listing](dokvs.JSONSerializer[Listing]{}, &keys)
+	)
+
+	v, err := serializer.Serialize(listing)
+	require.NoError(t, err)
+	assert.NotContains(t, string(v), "secret")
+
+	// values encrypted under previous keys remain readable after rotation
+	keys.Current = "k2"
+
+	var found Listing
+	require.NoError(t, serializer.Deserialize(v, &found))
+	assert.Equal(t, listing, found)
+
+	rotated, err := serializer.Serialize(listing)
+	require.NoError(t, err)
+	assert.Equal(t, "k2", string(rotated[1:1+rotated[0]]))
+
+	// tampering with the key ID or ciphertext fails authentication
+	tampered := append([]byte(nil), v...)
+	tampered[len(tampered)-1] ^= 0xFF
+	assert.ErrorIs(t, serializer.Deserialize(tampered, &found), dokvs.ErrInvalidCiphertext)
+
+	delete(keys.Keys, "k1")
+	assert.ErrorIs(t, serializer.Deserialize(v, &found), dokvs.ErrUnknownKey)
+
+	assert.Equal(t, "encrypted:json", serializer.ID())
+}
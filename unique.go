@@ -0,0 +1,117 @@
+package dokvs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/georgemac/dokvs/pkg/kv"
+)
+
+// UniqueConstraint declares that the value derived from a document by Value
+// must not be shared by two documents with different primary keys.
+// Documents for which Value returns nil are not constrained.
+type UniqueConstraint[D any] struct {
+	Name  string
+	Value func(D) []byte
+}
+
+// UniqueSchema is implemented by a CollectionSchema which declares unique
+// constraints. Schemas returned by NewSchema implement it.
+type UniqueSchema[D any] interface {
+	UniqueConstraints() []UniqueConstraint[D]
+}
+
+// uniqueConstraints returns the unique constraints declared by schema, if any.
+func uniqueConstraints[D any](schema CollectionSchema[D]) []UniqueConstraint[D] {
+	if unique, ok := schema.(UniqueSchema[D]); ok {
+		return unique.UniqueConstraints()
+	}
+
+	return nil
+}
+
+// WithUnique configures a unique constraint on a Schema.
+func WithUnique[D any](name string, fn func(D) []byte) func(*Schema[D]) {
+	return func(s *Schema[D]) {
+		s.unique = append(s.unique, UniqueConstraint[D]{Name: name, Value: fn})
+	}
+}
+
+// ErrUniqueViolation is returned when a document cannot be put because
+// it shares a uniquely constrained value with an existing document.
+type ErrUniqueViolation struct {
+	// Constraint is the name of the violated unique constraint.
+	Constraint string
+	// Key is the primary key of the existing document which holds the value.
+	Key []byte
+}
+
+// Error returns a string representation of the ErrUniqueViolation.
+func (e *ErrUniqueViolation) Error() string {
+	return fmt.Sprintf("unique constraint %q violated: value held by key %q", e.Constraint, e.Key)
+}
+
+// uniqueKeyspace returns the name of the keyspace which maps the values
+// of the named unique constraint to the primary keys which hold them.
+func uniqueKeyspace(collection []byte, name string) []byte {
+	return []byte(string(collection) + ":unique:" + name)
+}
+
+// checkUnique returns an *ErrUniqueViolation when any of the uniquely
+// constrained values of doc is held by a document other than pk.
+func (c CollectionUpdate[D, K]) checkUnique(ctx context.Context, pk []byte, doc D) error {
+	for i, unique := range uniqueConstraints(c.schema) {
+		v := unique.Value(doc)
+		if v == nil {
+			continue
+		}
+
+		items, err := c.uniqueUpdates[i].Get(ctx, kv.Key(v))
+		if err != nil {
+			var berr *kv.BatchError
+			if errors.As(err, &berr) && errors.Is(berr.Errors[0], kv.ErrKeyNotFound) {
+				continue
+			}
+
+			return err
+		}
+
+		if owner := items[0].V; !bytes.Equal(owner, pk) {
+			return &ErrUniqueViolation{
+				Constraint: unique.Name,
+				Key:        append([]byte(nil), owner...),
+			}
+		}
+	}
+
+	return nil
+}
+
+// uniqueChanges accumulates into changes the release of the values held by old
+// and the claim of those held by new. Either old or new may be nil.
+func (c Collection[D, K]) uniqueChanges(changes []keyspaceChanges, pk []byte, old, new *D) {
+	for i, unique := range uniqueConstraints(c.schema) {
+		var oldV, newV []byte
+		if old != nil {
+			oldV = unique.Value(*old)
+		}
+
+		if new != nil {
+			newV = unique.Value(*new)
+		}
+
+		if oldV != nil && newV != nil && bytes.Equal(oldV, newV) {
+			continue
+		}
+
+		if oldV != nil {
+			changes[i].delete(oldV, pk)
+		}
+
+		if newV != nil {
+			changes[i].put(newV, pk)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package dokvs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Violation is a single reason for which a document is invalid.
+type Violation struct {
+	// Field is the dot separated path of the invalid field, e.g. "address.city".
+	// It is empty when the violation does not concern a particular field.
+	Field   string
+	Message string
+}
+
+func (v Violation) String() string {
+	if v.Field == "" {
+		return v.Message
+	}
+
+	return v.Field + ": " + v.Message
+}
+
+// ValidationError is returned when a document cannot be written because it
+// fails validation. Validators may return a *ValidationError to report
+// violations of particular fields. Any other error they return is reported
+// as a violation without a field. A validator returning a nil
+// *ValidationError, or one without violations, reports the document as valid.
+type ValidationError struct {
+	// Key is the primary key of the invalid document.
+	Key        []byte
+	Violations []Violation
+}
+
+// Error returns a string representation of the ValidationError.
+func (e *ValidationError) Error() string {
+	violations := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		violations[i] = v.String()
+	}
+
+	return fmt.Sprintf("document %q is invalid: %s", e.Key, strings.Join(violations, "; "))
+}
+
+// Add appends a violation of the field, returning the ValidationError so that
+// validators can build errors fluently, e.g. (&ValidationError{}).Add("id", "required").
+func (e *ValidationError) Add(field, message string) *ValidationError {
+	e.Violations = append(e.Violations, Violation{Field: field, Message: message})
+	return e
+}
+
+// Err returns e when any violations have been added and otherwise nil.
+func (e *ValidationError) Err() error {
+	if len(e.Violations) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// Validator is implemented by documents which validate themselves.
+// Collections call Validate before writing any document which implements it,
+// either on the document type or a pointer to it.
+type Validator interface {
+	Validate() error
+}
+
+// WithValidator configures a function which validates documents before
+// they are written. Documents for which any validator returns an error are
+// rejected with a *ValidationError.
+func WithValidator[D any, K AnyBytes](fn func(D) error) func(*Collection[D, K]) {
+	return func(c *Collection[D, K]) {
+		c.validators = append(c.validators, fn)
+	}
+}
+
+// validate returns a *ValidationError containing the violations reported by
+// the Validate method of d and every configured validator, or nil when valid.
+func (c Collection[D, K]) validate(d D) error {
+	verr := &ValidationError{}
+	add := func(err error) {
+		if err == nil {
+			return
+		}
+
+		var violations *ValidationError
+		if errors.As(err, &violations) {
+			// a typed nil or an empty ValidationError reports no violations
+			if violations != nil {
+				verr.Violations = append(verr.Violations, violations.Violations...)
+			}
+
+			return
+		}
+
+		verr.Violations = append(verr.Violations, Violation{Message: err.Error()})
+	}
+
+	if v, ok := any(d).(Validator); ok {
+		add(v.Validate())
+	} else if v, ok := any(&d).(Validator); ok {
+		add(v.Validate())
+	}
+
+	for _, fn := range c.validators {
+		add(fn(d))
+	}
+
+	if len(verr.Violations) == 0 {
+		return nil
+	}
+
+	verr.Key = c.schema.PrimaryKey(d)
+
+	return verr
+}